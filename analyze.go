@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lk16/dense/huffman"
+	"io"
+	"os"
+)
+
+// Prints statistics of a file without compressing it
+func runAnalyze(args []string) int {
+
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	flag_format := flags.String("format", "text", "Output format, text or json.")
+	flags.Parse(args)
+
+	if *flag_format != "text" && *flag_format != "json" {
+		return fail(EXIT_USAGE, "Unknown format '%s'.", *flag_format)
+	}
+
+	if flags.NArg() > 1 {
+		return fail(EXIT_USAGE, "Expected at most one input file.")
+	}
+
+	input_name := flags.Arg(0)
+	input_file := os.Stdin
+
+	if input_name != "" {
+		var err error
+		if input_file, err = os.Open(input_name); err != nil {
+			return fail(EXIT_IO, "%s", err)
+		}
+		defer input_file.Close()
+	}
+
+	analysis, err := huffman.Analyze(input_file)
+	if err != nil {
+		return fail(EXIT_IO, "%s: %s", displayName(input_name), err)
+	}
+
+	if *flag_format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(analysis)
+	} else {
+		err = printAnalysis(os.Stdout, analysis)
+	}
+
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+	return EXIT_OK
+}
+
+func printAnalysis(writer io.Writer, analysis *huffman.Analysis) (err error) {
+
+	percentage := func(count int64) float64 {
+		if analysis.Size == 0 {
+			return 0
+		}
+		return 100 * float64(count) / float64(analysis.Size)
+	}
+
+	fmt.Fprintf(writer, "size:               %d\n", analysis.Size)
+	fmt.Fprintf(writer, "order-0 entropy:    %.3f bits/byte\n", analysis.Order0Entropy)
+	fmt.Fprintf(writer, "order-1 entropy:    %.3f bits/byte\n", analysis.Order1Entropy)
+	fmt.Fprintf(writer, "order-2 entropy:    %.3f bits/byte\n", analysis.Order2Entropy)
+	fmt.Fprintf(writer, "runs:               %d\n", analysis.Runs)
+	fmt.Fprintf(writer, "longest run:        %d\n", analysis.LongestRun)
+	fmt.Fprintf(writer, "repeated sequences: %d (%.1f%%)\n", analysis.RepeatedSequences,
+		percentage(analysis.RepeatedSequences))
+
+	fmt.Fprintf(writer, "\n%16s %8s %12s %8s\n", "method", "levels", "size", "ratio")
+	for _, prediction := range analysis.Predictions {
+		fmt.Fprintf(writer, "%16s %8s %12d %7.1f%%\n", prediction.Method, prediction.Levels,
+			prediction.Size, percentage(prediction.Size))
+	}
+
+	fmt.Fprintf(writer, "\n%6s %12s %8s %12s\n", "byte", "count", "share", "code length")
+	for b, count := range analysis.Histogram {
+		if count == 0 {
+			continue
+		}
+		_, err = fmt.Fprintf(writer, "%6s %12d %7.2f%% %12d\n", byteName(byte(b)), count, percentage(count),
+			analysis.CodeLengths[b])
+	}
+	return
+}
+
+// Returns a printable byte as is, others as hexadecimal
+func byteName(b byte) string {
+	if b > ' ' && b < 0x7F {
+		return string(rune(b))
+	}
+	return fmt.Sprintf("0x%02x", b)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lk16/dense/huffman"
+	"io"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval at which the heap size is sampled to find the peak memory use
+const BENCH_MEMORY_INTERVAL = time.Millisecond
+
+// Compression method as measured by 'dense bench'
+type benchCodec struct {
+	method     string
+	level      int
+	compress   func(data []byte, writer io.Writer) error
+	decompress func(reader io.Reader, writer io.Writer) error
+}
+
+// Result of one codec on one file
+type benchResult struct {
+	File             string  `json:"file"`
+	Method           string  `json:"method"`
+	Level            int     `json:"level"`
+	Size             int64   `json:"size"`
+	CompressedSize   int64   `json:"compressed_size"`
+	Ratio            float64 `json:"ratio"`
+	CompressSpeed    float64 `json:"compress_mb_per_s"`
+	DecompressSpeed  float64 `json:"decompress_mb_per_s"`
+	CompressMemory   uint64  `json:"compress_peak_memory"`
+	DecompressMemory uint64  `json:"decompress_peak_memory"`
+}
+
+// Measures compression ratio, speed and memory use of every level, optionally next to the standard library
+func runBench(args []string) int {
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flag_format := flags.String("format", "text", "Output format, text, csv or json.")
+	flag_compare := flags.Bool("compare", false, "Also measure gzip, zlib, flate and lzw.")
+	flag_levels := flags.String("levels", "1-9", "Levels to measure, e.g. 1,6,9 or 1-9.")
+	flag_count := flags.Int("count", 3, "Number of runs per measurement, of which the fastest counts.")
+	flags.Parse(args)
+
+	if *flag_format != "text" && *flag_format != "csv" && *flag_format != "json" {
+		return fail(EXIT_USAGE, "Unknown format '%s'.", *flag_format)
+	}
+
+	levels, err := parseLevels(*flag_levels)
+	if err != nil || *flag_count < 1 {
+		return fail(EXIT_USAGE, "Invalid levels '%s' or count %d.", *flag_levels, *flag_count)
+	}
+
+	if flags.NArg() == 0 {
+		return fail(EXIT_USAGE, "Expected files to measure.")
+	}
+
+	codecs := benchCodecs(levels, *flag_compare)
+
+	var results []benchResult
+	for _, file_name := range flags.Args() {
+		data, err := os.ReadFile(file_name)
+		if err != nil {
+			return fail(EXIT_IO, "%s", err)
+		}
+
+		for _, codec := range codecs {
+			result, err := codec.measure(data, *flag_count)
+			if err != nil {
+				return fail(EXIT_FORMAT, "%s: %s level %d: %s", file_name, codec.method, codec.level, err)
+			}
+			result.File = file_name
+			results = append(results, result)
+		}
+	}
+
+	switch *flag_format {
+	case "csv":
+		err = writeBenchCSV(os.Stdout, results)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(results)
+	default:
+		err = writeBenchText(os.Stdout, results)
+	}
+
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+	return EXIT_OK
+}
+
+// Parses a comma separated list of levels and ranges of levels
+func parseLevels(value string) (levels []int, err error) {
+
+	for _, part := range strings.Split(value, ",") {
+		first, last, is_range := strings.Cut(part, "-")
+
+		var from, to int
+		if from, err = strconv.Atoi(first); err != nil {
+			return
+		}
+
+		to = from
+		if is_range {
+			if to, err = strconv.Atoi(last); err != nil {
+				return
+			}
+		}
+
+		if from < huffman.MIN_LEVEL || to > huffman.MAX_LEVEL || from > to {
+			err = fmt.Errorf("Invalid level range %s", part)
+			return
+		}
+
+		for level := from; level <= to; level++ {
+			levels = append(levels, level)
+		}
+	}
+	return
+}
+
+func benchCodecs(levels []int, compare bool) (codecs []benchCodec) {
+
+	decompress_dense := func(reader io.Reader, writer io.Writer) error {
+		return huffman.Decode(reader, writer)
+	}
+
+	for _, level := range levels {
+		level := level
+		codecs = append(codecs, benchCodec{
+			method: "dense",
+			level:  level,
+			compress: func(data []byte, writer io.Writer) error {
+				return huffman.EncodeWithOptions(bytes.NewReader(data), writer, huffman.LevelOptions(level))
+			},
+			decompress: decompress_dense})
+	}
+
+	if !compare {
+		return
+	}
+
+	// Wraps a compressing writer constructor in a compress function
+	compressor := func(new_writer func(io.Writer) (io.WriteCloser, error)) func([]byte, io.Writer) error {
+		return func(data []byte, writer io.Writer) (err error) {
+			compressor, err := new_writer(writer)
+			if err != nil {
+				return
+			}
+			if _, err = compressor.Write(data); err != nil {
+				return
+			}
+			return compressor.Close()
+		}
+	}
+
+	// Wraps a decompressing reader constructor in a decompress function
+	decompressor := func(new_reader func(io.Reader) (io.ReadCloser, error)) func(io.Reader, io.Writer) error {
+		return func(reader io.Reader, writer io.Writer) (err error) {
+			decompressor, err := new_reader(reader)
+			if err != nil {
+				return
+			}
+			defer decompressor.Close()
+			_, err = io.Copy(writer, decompressor)
+			return
+		}
+	}
+
+	for _, level := range levels {
+		level := level
+		codecs = append(codecs,
+			benchCodec{
+				method: "gzip",
+				level:  level,
+				compress: compressor(func(writer io.Writer) (io.WriteCloser, error) {
+					return gzip.NewWriterLevel(writer, level)
+				}),
+				decompress: decompressor(func(reader io.Reader) (io.ReadCloser, error) {
+					return gzip.NewReader(reader)
+				})},
+			benchCodec{
+				method: "zlib",
+				level:  level,
+				compress: compressor(func(writer io.Writer) (io.WriteCloser, error) {
+					return zlib.NewWriterLevel(writer, level)
+				}),
+				decompress: decompressor(zlib.NewReader)},
+			benchCodec{
+				method: "flate",
+				level:  level,
+				compress: compressor(func(writer io.Writer) (io.WriteCloser, error) {
+					return flate.NewWriter(writer, level)
+				}),
+				decompress: decompressor(func(reader io.Reader) (io.ReadCloser, error) {
+					return flate.NewReader(reader), nil
+				})})
+	}
+
+	// LZW has no levels
+	codecs = append(codecs, benchCodec{
+		method: "lzw",
+		compress: compressor(func(writer io.Writer) (io.WriteCloser, error) {
+			return lzw.NewWriter(writer, lzw.LSB, 8), nil
+		}),
+		decompress: decompressor(func(reader io.Reader) (io.ReadCloser, error) {
+			return lzw.NewReader(reader, lzw.LSB, 8), nil
+		})})
+	return
+}
+
+// Compresses and decompresses data count times and checks the result
+func (codec *benchCodec) measure(data []byte, count int) (result benchResult, err error) {
+
+	var compressed, decompressed bytes.Buffer
+
+	compress_time, compress_memory, err := measureRuns(count, func() error {
+		compressed.Reset()
+		return codec.compress(data, &compressed)
+	})
+	if err != nil {
+		return
+	}
+
+	decompress_time, decompress_memory, err := measureRuns(count, func() error {
+		decompressed.Reset()
+		return codec.decompress(bytes.NewReader(compressed.Bytes()), &decompressed)
+	})
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(data, decompressed.Bytes()) {
+		err = fmt.Errorf("Decompressed data differs from input")
+		return
+	}
+
+	// Speed in MB/s of processing data in the given time
+	speed := func(duration time.Duration) float64 {
+		return float64(len(data)) / (1 << 20) / duration.Seconds()
+	}
+
+	result = benchResult{
+		Method:           codec.method,
+		Level:            codec.level,
+		Size:             int64(len(data)),
+		CompressedSize:   int64(compressed.Len()),
+		CompressSpeed:    speed(compress_time),
+		DecompressSpeed:  speed(decompress_time),
+		CompressMemory:   compress_memory,
+		DecompressMemory: decompress_memory}
+
+	if len(data) > 0 {
+		result.Ratio = float64(compressed.Len()) / float64(len(data))
+	}
+	return
+}
+
+// Runs function count times and returns the fastest run and the highest heap growth of any run.
+// The output buffers of the function count towards its memory use.
+func measureRuns(count int, function func() error) (fastest time.Duration, peak_memory uint64, err error) {
+
+	for i := 0; i < count; i++ {
+		runtime.GC()
+		baseline := heapSize()
+
+		var peak uint64
+		var wait sync.WaitGroup
+		done := make(chan bool)
+
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			ticker := time.NewTicker(BENCH_MEMORY_INTERVAL)
+			defer ticker.Stop()
+
+			for {
+				peak = max(peak, heapSize())
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+
+		start := time.Now()
+		err = function()
+		duration := time.Since(start)
+
+		close(done)
+		wait.Wait()
+		peak = max(peak, heapSize())
+
+		if err != nil {
+			return
+		}
+
+		if i == 0 || duration < fastest {
+			fastest = duration
+		}
+
+		if peak > baseline {
+			peak_memory = max(peak_memory, peak-baseline)
+		}
+	}
+	return
+}
+
+// Returns the number of bytes of live and not yet collected heap objects
+func heapSize() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+func writeBenchText(writer io.Writer, results []benchResult) (err error) {
+
+	fmt.Fprintf(writer, "%-20s %-6s %5s %12s %12s %7s %12s %12s %10s %10s\n", "file", "method", "level",
+		"size", "compressed", "ratio", "comp MB/s", "decomp MB/s", "comp mem", "decomp mem")
+
+	for _, result := range results {
+		_, err = fmt.Fprintf(writer, "%-20s %-6s %5d %12d %12d %6.1f%% %12.1f %12.1f %10s %10s\n",
+			result.File, result.Method, result.Level, result.Size, result.CompressedSize, 100*result.Ratio,
+			result.CompressSpeed, result.DecompressSpeed, formatSize(int64(result.CompressMemory)),
+			formatSize(int64(result.DecompressMemory)))
+	}
+	return
+}
+
+func writeBenchCSV(writer io.Writer, results []benchResult) (err error) {
+
+	csv_writer := csv.NewWriter(writer)
+	csv_writer.Write([]string{"file", "method", "level", "size", "compressed_size", "ratio",
+		"compress_mb_per_s", "decompress_mb_per_s", "compress_peak_memory", "decompress_peak_memory"})
+
+	for _, result := range results {
+		csv_writer.Write([]string{
+			result.File,
+			result.Method,
+			strconv.Itoa(result.Level),
+			strconv.FormatInt(result.Size, 10),
+			strconv.FormatInt(result.CompressedSize, 10),
+			strconv.FormatFloat(result.Ratio, 'f', 4, 64),
+			strconv.FormatFloat(result.CompressSpeed, 'f', 2, 64),
+			strconv.FormatFloat(result.DecompressSpeed, 'f', 2, 64),
+			strconv.FormatUint(result.CompressMemory, 10),
+			strconv.FormatUint(result.DecompressMemory, 10)})
+	}
+
+	csv_writer.Flush()
+	return csv_writer.Error()
+}
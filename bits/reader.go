@@ -42,7 +42,7 @@ func (reader *Reader) FlushBits() {
 }
 
 func (reader *Reader) doRead() (err error) {
-	_, err = reader.reader.Read(reader.buff)
+	_, err = io.ReadFull(reader.reader, reader.buff)
 	if err != nil {
 		return
 	}
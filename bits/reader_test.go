@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNewReader(t *testing.T) {
@@ -85,3 +86,80 @@ func TestReaderFlushBits(t *testing.T) {
 		t.Errorf("Expected 0, got %d", reader.bits_left)
 	}
 }
+
+func TestReaderShortReads(t *testing.T) {
+
+	input := []byte{0xA1, 0x00, 0xFF}
+
+	readers := []io.Reader{
+		iotest.OneByteReader(bytes.NewReader(input)),
+		iotest.HalfReader(bytes.NewReader(input)),
+		iotest.DataErrReader(bytes.NewReader(input))}
+
+	for _, underlying := range readers {
+		reader := NewReader(underlying)
+
+		for i := 0; i < 8*len(input); i++ {
+			bit, err := reader.ReadBit()
+
+			if err != nil {
+				t.Fatalf("At bit %d: got error %s", i, err)
+			}
+
+			expected := input[i/8]&(0x80>>uint(i%8)) != 0
+			if bit != expected {
+				t.Errorf("At bit %d: expected %t, got %t", i, expected, bit)
+			}
+		}
+
+		if _, err := reader.ReadBit(); err != io.EOF {
+			t.Errorf("Expected 'EOF', got %v", err)
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+
+	f.Add([]byte{})
+	f.Add([]byte{0xA1})
+	f.Add([]byte{0x00, 0xFF, 0x5A})
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		reader := NewReader(bytes.NewReader(input))
+
+		// reading bit by bit yields the input again
+		var output bytes.Buffer
+		writer := NewWriter(&output)
+
+		for {
+			bit, err := reader.ReadBit()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("Got unexpected error %s", err)
+				}
+				break
+			}
+			writer.WriteBit(bit)
+		}
+		writer.Flush()
+
+		if !bytes.Equal(input, output.Bytes()) {
+			t.Errorf("Expected %v, got %v", input, output.Bytes())
+		}
+	})
+}
+
+func BenchmarkReaderReadBit(b *testing.B) {
+	data := bytes.Repeat([]byte{0xA5}, 1<<16)
+	reader := NewReader(bytes.NewReader(data))
+	b.SetBytes(1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 8; j++ {
+			if _, err := reader.ReadBit(); err == io.EOF {
+				reader = NewReader(bytes.NewReader(data))
+			}
+		}
+	}
+}
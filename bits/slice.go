@@ -65,3 +65,8 @@ func (slice *Slice) PopLeadingBytes() (bytes []byte) {
 	}
 	return
 }
+
+// Returns the number of bits in the slice
+func (slice *Slice) Len() int {
+	return slice.length
+}
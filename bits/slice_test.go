@@ -128,3 +128,15 @@ func TestPopLeadingBytes(t *testing.T) {
 	}
 
 }
+
+func TestSliceLen(t *testing.T) {
+	slice := NewSlice(5, 0x1f)
+	if slice.Len() != 5 {
+		t.Errorf("Expected 5, got %d", slice.Len())
+	}
+
+	slice.AppendBit(true)
+	if slice.Len() != 6 {
+		t.Errorf("Expected 6, got %d", slice.Len())
+	}
+}
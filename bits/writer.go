@@ -4,16 +4,23 @@ import (
 	"io"
 )
 
+// Number of bytes a Writer buffers before writing them to the underlying writer
+const BUFFER_SIZE = 4096
+
+// Writes bits, which are buffered until the buffer is full or Flush, FlushBits or Close is called
 type Writer struct {
 	writer io.Writer
 	slice  Slice
+	buff   []byte
+	err    error
 }
 
 // Creates a new writer
 func NewWriter(writer io.Writer) *Writer {
 	return &Writer{
 		writer: writer,
-		slice:  *NewSlice(0, 0x0)}
+		slice:  *NewSlice(0, 0x0),
+		buff:   make([]byte, 0, BUFFER_SIZE+8)}
 }
 
 // Writes a bit
@@ -34,14 +41,42 @@ func (writer *Writer) CountUnflushedBits() (count int) {
 	return
 }
 
+// Writes the buffered bytes to the underlying writer. Bits not making up a byte remain buffered.
+func (writer *Writer) Flush() error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	if len(writer.buff) > 0 {
+		_, writer.err = writer.writer.Write(writer.buff)
+		writer.buff = writer.buff[:0]
+	}
+	return writer.err
+}
+
 // Pad and write last bits
 func (writer *Writer) FlushBits() (err error) {
 	writer.slice.AppendPadding()
-	return writer.doWrite()
+	if err = writer.doWrite(); err != nil {
+		return
+	}
+	return writer.Flush()
 }
 
+// Pads and writes the last bits. It does not close the underlying writer.
+func (writer *Writer) Close() error {
+	return writer.FlushBits()
+}
+
+// Moves whole bytes from the slice to the buffer, which is written once it is full
 func (writer *Writer) doWrite() (err error) {
-	bytes := writer.slice.PopLeadingBytes()
-	_, err = writer.writer.Write(bytes)
-	return
+	for writer.slice.length >= 8 {
+		writer.buff = append(writer.buff, byte(writer.slice.data>>uint(writer.slice.length-8)))
+		writer.slice.length -= 8
+	}
+
+	if len(writer.buff) >= BUFFER_SIZE {
+		return writer.Flush()
+	}
+	return writer.err
 }
@@ -2,6 +2,7 @@ package bits
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -9,7 +10,7 @@ func TestNewBitsWriter(t *testing.T) {
 	var buff bytes.Buffer
 	bw := NewWriter(&buff)
 
-	if bw.writer != &buff || bw.slice != *NewSlice(0, 0x0) {
+	if bw.writer != &buff || bw.slice != *NewSlice(0, 0x0) || len(bw.buff) != 0 {
 		t.Errorf("NewBitsWriter failed")
 	}
 }
@@ -23,6 +24,12 @@ func TestBitsWriterWriteBit(t *testing.T) {
 		bw.WriteBit(i < 4)
 	}
 
+	// bytes are buffered until flushed
+	if buff.Len() != 0 {
+		t.Errorf("Expected no output before Flush, got %v", buff.Bytes())
+	}
+	bw.Flush()
+
 	bytes := buff.Bytes()
 	if len(bytes) != 1 || bytes[0] != 0xF0 {
 		t.Errorf("Expected [0xF0], got %v", bytes)
@@ -38,6 +45,7 @@ func TestBitsWriterWriteSlice(t *testing.T) {
 
 	bw.WriteSlice(&slice)
 	bw.WriteSlice(&slice)
+	bw.Flush()
 
 	bytes := buff.Bytes()
 	if len(bytes) != 1 || bytes[0] != 0xFF {
@@ -97,3 +105,104 @@ func BitsWriterCountUnflushedBits(t *testing.T) {
 		t.Errorf("Expected 0, got %d", bw.CountUnflushedBits())
 	}
 }
+
+func TestBitsWriterBuffer(t *testing.T) {
+	var buff bytes.Buffer
+	bw := NewWriter(&buff)
+
+	slice := *NewSlice(8, 0xAB)
+
+	for i := 0; i < BUFFER_SIZE-1; i++ {
+		bw.WriteSlice(&slice)
+	}
+
+	if buff.Len() != 0 {
+		t.Errorf("Expected no output, got %d bytes", buff.Len())
+	}
+
+	// a full buffer is written
+	bw.WriteSlice(&slice)
+
+	if buff.Len() != BUFFER_SIZE {
+		t.Errorf("Expected %d bytes, got %d", BUFFER_SIZE, buff.Len())
+	}
+
+	// flushing keeps bits which do not make up a byte
+	bw.WriteBit(true)
+	bw.WriteSlice(&slice)
+	bw.Flush()
+
+	if buff.Len() != BUFFER_SIZE+1 || bw.CountUnflushedBits() != 1 {
+		t.Errorf("Expected %d bytes and 1 unflushed bit, got %d and %d", BUFFER_SIZE+1, buff.Len(),
+			bw.CountUnflushedBits())
+	}
+
+	bw.Close()
+
+	if buff.Len() != BUFFER_SIZE+2 || buff.Bytes()[BUFFER_SIZE+1] != 0x80 {
+		t.Errorf("Expected padded last byte 0x80, got %v", buff.Bytes()[BUFFER_SIZE:])
+	}
+}
+
+func TestBitsWriterError(t *testing.T) {
+	bw := NewWriter(errWriter{})
+
+	bw.WriteSlice(NewSlice(8, 0xFF))
+
+	if err := bw.Flush(); err != io.ErrShortWrite {
+		t.Errorf("Expected io.ErrShortWrite, got %v", err)
+	}
+
+	// the error is kept
+	if err := bw.WriteSlice(NewSlice(8, 0xFF)); err != io.ErrShortWrite {
+		t.Errorf("Expected io.ErrShortWrite, got %v", err)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(buff []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestBitsWriterAllocs(t *testing.T) {
+	bw := NewWriter(io.Discard)
+	slice := NewSlice(5, 0x15)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := 0; i < 10000; i++ {
+			bw.WriteSlice(slice)
+			bw.WriteBit(i%2 == 0)
+		}
+		bw.FlushBits()
+	})
+
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %.1f", allocs)
+	}
+}
+
+func BenchmarkWriterWriteBit(b *testing.B) {
+	bw := NewWriter(io.Discard)
+	b.SetBytes(1)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 8; j++ {
+			bw.WriteBit(j%3 == 0)
+		}
+	}
+}
+
+func BenchmarkWriterWriteSlice(b *testing.B) {
+	bw := NewWriter(io.Discard)
+	slice := NewSlice(5, 0x15)
+	b.SetBytes(5)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 8; j++ {
+			bw.WriteSlice(slice)
+		}
+	}
+}
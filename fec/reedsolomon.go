@@ -0,0 +1,274 @@
+package fec
+
+import (
+	"errors"
+)
+
+// Maximum length of a codeword, data and parity bytes together
+const MAX_CODEWORD_LEN = 255
+
+var (
+	ErrInvalidCode    = errors.New("Invalid Reed-Solomon code")
+	ErrTooManyErrors  = errors.New("Too many errors to correct")
+	ErrInvalidLengths = errors.New("Invalid codeword length")
+)
+
+// Exponents and logarithms of GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1.
+// The exponent table is doubled, so products of two logarithms need no reduction.
+var (
+	exp_table [2 * MAX_CODEWORD_LEN]byte
+	log_table [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < MAX_CODEWORD_LEN; i++ {
+		exp_table[i] = byte(x)
+		exp_table[i+MAX_CODEWORD_LEN] = byte(x)
+		log_table[x] = i
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp_table[log_table[a]+log_table[b]]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return exp_table[log_table[a]+MAX_CODEWORD_LEN-log_table[b]]
+}
+
+// Returns alpha to the power of exponent, which may be negative
+func pow(exponent int) byte {
+	exponent %= MAX_CODEWORD_LEN
+	if exponent < 0 {
+		exponent += MAX_CODEWORD_LEN
+	}
+	return exp_table[exponent]
+}
+
+// Evaluates a polynomial, with coefficients from the lowest power up, at x
+func eval(poly []byte, x byte) (y byte) {
+	for i := len(poly) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ poly[i]
+	}
+	return
+}
+
+// Systematic Reed-Solomon code, which corrects up to half as many damaged bytes
+// of a codeword as it has parity bytes
+type Code struct {
+	data_len   int
+	parity_len int
+
+	// Generator polynomial with roots alpha^0 to alpha^(parity_len-1), from the highest power down
+	generator []byte
+}
+
+// Creates a code of codewords with data_len data bytes followed by parity_len parity bytes
+func NewCode(data_len, parity_len int) (code *Code, err error) {
+
+	if data_len < 1 || parity_len < 1 || data_len+parity_len > MAX_CODEWORD_LEN {
+		return nil, ErrInvalidCode
+	}
+
+	generator := []byte{1}
+	for i := 0; i < parity_len; i++ {
+		// multiply by (x - alpha^i)
+		next := make([]byte, len(generator)+1)
+		for j, coefficient := range generator {
+			next[j] ^= coefficient
+			next[j+1] ^= mul(coefficient, pow(i))
+		}
+		generator = next
+	}
+
+	code = &Code{
+		data_len:   data_len,
+		parity_len: parity_len,
+		generator:  generator}
+	return
+}
+
+// Returns the number of data bytes of a codeword
+func (code *Code) DataLen() int {
+	return code.data_len
+}
+
+// Returns the number of parity bytes of a codeword
+func (code *Code) ParityLen() int {
+	return code.parity_len
+}
+
+// Computes the parity bytes of data, the remainder of dividing it by the generator
+func (code *Code) Encode(data, parity []byte) error {
+
+	if len(data) != code.data_len || len(parity) != code.parity_len {
+		return ErrInvalidLengths
+	}
+
+	clear(parity)
+	for _, b := range data {
+		feedback := b ^ parity[0]
+		copy(parity, parity[1:])
+		parity[code.parity_len-1] = 0
+
+		if feedback != 0 {
+			for j := range parity {
+				parity[j] ^= mul(code.generator[j+1], feedback)
+			}
+		}
+	}
+	return nil
+}
+
+// Corrects a codeword of data followed by parity bytes in place and returns the number of corrected bytes.
+// Fails with ErrTooManyErrors, leaving the codeword unchanged, if it cannot be corrected.
+func (code *Code) Decode(codeword []byte) (corrected int, err error) {
+
+	n := code.data_len + code.parity_len
+	if len(codeword) != n {
+		return 0, ErrInvalidLengths
+	}
+
+	syndromes, ok := code.syndromes(codeword)
+	if ok {
+		return 0, nil
+	}
+
+	locator := berlekampMassey(syndromes)
+	error_count := len(locator) - 1
+	if 2*error_count > code.parity_len {
+		return 0, ErrTooManyErrors
+	}
+
+	// Omega(x) = S(x) Lambda(x) mod x^parity_len
+	evaluator := make([]byte, code.parity_len)
+	for i := range evaluator {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= mul(syndromes[i-j], locator[j])
+		}
+	}
+
+	// Formal derivative, in which only the odd powers remain
+	derivative := make([]byte, len(locator)-1)
+	for i := range derivative {
+		if i%2 == 0 {
+			derivative[i] = locator[i+1]
+		}
+	}
+
+	type correction struct {
+		index     int
+		magnitude byte
+	}
+	var corrections []correction
+
+	// Chien search for the roots of the locator, the inverses of the error positions
+	for index := 0; index < n; index++ {
+		degree := n - 1 - index
+		x_inverse := pow(-degree)
+
+		if eval(locator, x_inverse) != 0 {
+			continue
+		}
+
+		denominator := eval(derivative, x_inverse)
+		if denominator == 0 {
+			return 0, ErrTooManyErrors
+		}
+
+		// Forney, for generator roots starting at alpha^0
+		magnitude := mul(pow(degree), div(eval(evaluator, x_inverse), denominator))
+		corrections = append(corrections, correction{index, magnitude})
+	}
+
+	if len(corrections) != error_count {
+		return 0, ErrTooManyErrors
+	}
+
+	for _, fix := range corrections {
+		codeword[fix.index] ^= fix.magnitude
+	}
+
+	// A codeword with more errors than the code can correct may be miscorrected into another invalid one
+	if _, ok = code.syndromes(codeword); !ok {
+		for _, fix := range corrections {
+			codeword[fix.index] ^= fix.magnitude
+		}
+		return 0, ErrTooManyErrors
+	}
+	return error_count, nil
+}
+
+// Returns the codeword evaluated at the roots of the generator. Ok is true if all are zero.
+func (code *Code) syndromes(codeword []byte) (syndromes []byte, ok bool) {
+
+	syndromes = make([]byte, code.parity_len)
+	ok = true
+
+	for i := range syndromes {
+		root := pow(i)
+		for _, b := range codeword {
+			syndromes[i] = mul(syndromes[i], root) ^ b
+		}
+		ok = ok && syndromes[i] == 0
+	}
+	return
+}
+
+// Returns the error locator polynomial for the syndromes, from the lowest power up
+func berlekampMassey(syndromes []byte) []byte {
+
+	locator := []byte{1}
+	previous := []byte{1}
+	length := 0
+	shift := 1
+	previous_discrepancy := byte(1)
+
+	for n := range syndromes {
+		discrepancy := syndromes[n]
+		for i := 1; i <= length && i < len(locator); i++ {
+			discrepancy ^= mul(locator[i], syndromes[n-i])
+		}
+
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+
+		// locator - discrepancy / previous_discrepancy * x^shift * previous
+		factor := div(discrepancy, previous_discrepancy)
+		next := make([]byte, max(len(locator), len(previous)+shift))
+		copy(next, locator)
+		for i, coefficient := range previous {
+			next[i+shift] ^= mul(factor, coefficient)
+		}
+
+		if 2*length <= n {
+			previous = locator
+			length = n + 1 - length
+			previous_discrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = next
+	}
+
+	// drop high powers which are zero
+	for len(locator) > length+1 {
+		locator = locator[:len(locator)-1]
+	}
+	return locator
+}
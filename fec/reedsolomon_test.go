@@ -0,0 +1,114 @@
+package fec
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func encodedCodeword(t *testing.T, code *Code, random *rand.Rand) []byte {
+	codeword := make([]byte, code.DataLen()+code.ParityLen())
+	random.Read(codeword[:code.DataLen()])
+
+	if err := code.Encode(codeword[:code.DataLen()], codeword[code.DataLen():]); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+	return codeword
+}
+
+func TestGaloisField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if div(mul(byte(a), 0x53), 0x53) != byte(a) {
+			t.Errorf("Expected %d * 0x53 / 0x53 to be %d", a, a)
+		}
+	}
+
+	// alpha generates all non-zero elements
+	seen := make(map[byte]bool)
+	for i := 0; i < MAX_CODEWORD_LEN; i++ {
+		seen[pow(i)] = true
+	}
+
+	if len(seen) != 255 || seen[0] {
+		t.Errorf("Expected 255 distinct non-zero powers, got %d", len(seen))
+	}
+}
+
+func TestNewCodeInvalid(t *testing.T) {
+	for _, lengths := range [][2]int{{0, 10}, {10, 0}, {200, 56}} {
+		if _, err := NewCode(lengths[0], lengths[1]); err != ErrInvalidCode {
+			t.Errorf("Expected '%s' for %v, got %v", ErrInvalidCode, lengths, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+
+	random := rand.New(rand.NewSource(1))
+
+	for _, lengths := range [][2]int{{1, 2}, {20, 10}, {223, 32}, {100, 1}, {128, 127}} {
+		code, err := NewCode(lengths[0], lengths[1])
+		if err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		for error_count := 0; error_count <= code.ParityLen()/2; error_count++ {
+			codeword := encodedCodeword(t, code, random)
+			damaged := append([]byte{}, codeword...)
+
+			for _, index := range random.Perm(len(codeword))[:error_count] {
+				damaged[index] ^= byte(1 + random.Intn(255))
+			}
+
+			corrected, err := code.Decode(damaged)
+			if err != nil || corrected != error_count || !bytes.Equal(damaged, codeword) {
+				t.Errorf("Code %v with %d errors: corrected %d, error %v", lengths, error_count, corrected, err)
+			}
+		}
+	}
+}
+
+func TestDecodeTooManyErrors(t *testing.T) {
+
+	random := rand.New(rand.NewSource(2))
+	code, _ := NewCode(200, 20)
+
+	for i := 0; i < 100; i++ {
+		codeword := encodedCodeword(t, code, random)
+		damaged := append([]byte{}, codeword...)
+
+		for _, index := range random.Perm(len(codeword))[:30] {
+			damaged[index] ^= byte(1 + random.Intn(255))
+		}
+		before := append([]byte{}, damaged...)
+
+		// a miscorrection into another valid codeword is possible, but rare
+		if _, err := code.Decode(damaged); err == nil {
+			if bytes.Equal(damaged, codeword) {
+				t.Errorf("Expected 30 errors not to be correctable")
+			}
+			continue
+		}
+
+		if !bytes.Equal(damaged, before) {
+			t.Errorf("Expected uncorrectable codeword to be unchanged")
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+
+	random := rand.New(rand.NewSource(3))
+	code, _ := NewCode(223, 32)
+
+	codeword := make([]byte, 255)
+	random.Read(codeword[:223])
+	code.Encode(codeword[:223], codeword[223:])
+	b.SetBytes(255)
+
+	for i := 0; i < b.N; i++ {
+		damaged := append([]byte{}, codeword...)
+		damaged[i%255] ^= 0xFF
+		code.Decode(damaged)
+	}
+}
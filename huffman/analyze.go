@@ -0,0 +1,281 @@
+package huffman
+
+import (
+	"io"
+	"math"
+	"strconv"
+)
+
+// Number of entries of the hash table used to estimate repeated sequences
+const REPEAT_HASH_BITS = 24
+
+// Length of the sequences counted by Analysis.RepeatedSequences
+const REPEAT_SEQUENCE_LEN = 4
+
+// Size of the smallest blocks of any level, the block sizes of all levels are multiples of it
+const PREDICTION_CHUNK_SIZE = 64 << 10
+
+// Statistics of an input, as computed by Analyze
+type Analysis struct {
+	Size int64 `json:"size"`
+
+	// Number of occurrences of every byte value
+	Histogram [256]int64 `json:"histogram"`
+
+	// Empirical entropy in bits per byte, given no, one and two preceding bytes.
+	// The first byte is taken to be preceded by a zero byte, the first two bytes do not count for order 2.
+	Order0Entropy float64 `json:"order0_entropy"`
+	Order1Entropy float64 `json:"order1_entropy"`
+	Order2Entropy float64 `json:"order2_entropy"`
+
+	// Length of the Huffman code of every byte value, zero for bytes which do not occur
+	CodeLengths [256]int `json:"code_lengths"`
+
+	// Predicted compressed size of the input for every level
+	Predictions []Prediction `json:"predictions"`
+
+	// Number of runs of equal bytes and the length of the longest run
+	Runs       int64 `json:"runs"`
+	LongestRun int64 `json:"longest_run"`
+
+	// Estimated number of positions at which a sequence of REPEAT_SEQUENCE_LEN bytes starts
+	// that occurred before. Hash collisions make this an overestimate.
+	RepeatedSequences int64 `json:"repeated_sequences"`
+}
+
+type Prediction struct {
+	Method string `json:"method"`
+	Levels string `json:"levels"`
+	Size   int64  `json:"size"`
+}
+
+// Computes statistics of the input, which is read once
+func Analyze(reader io.Reader) (analysis *Analysis, err error) {
+
+	analysis = &Analysis{}
+
+	context_counts := &contextCounts{}
+	order2_counts := &order2Counts{}
+	seen_sequences := make([]uint64, (1<<REPEAT_HASH_BITS)/64)
+
+	// The last bytes read, the most recent in the lowest byte
+	history := uint32(0)
+	run_length := int64(0)
+
+	// Levels which split the input into blocks, each with a tree of its own
+	block_predictions := []*blockPrediction{}
+	for level := MIN_LEVEL; level <= MAX_LEVEL; level++ {
+		if level_block_sizes[level] != 0 {
+			block_predictions = append(block_predictions, &blockPrediction{level: level})
+		}
+	}
+	chunk_counts := make(map[byte]int64)
+
+	buff := make([]byte, 1<<16)
+
+	for {
+		var read_bytes int
+		read_bytes, err = reader.Read(buff)
+
+		for _, b := range buff[:read_bytes] {
+			analysis.Histogram[b]++
+			context_counts[byte(history)][b]++
+			chunk_counts[b]++
+
+			if analysis.Size >= 2 {
+				order2_counts.add(uint16(history), b)
+			}
+
+			if analysis.Size == 0 || b != byte(history) {
+				analysis.Runs++
+				run_length = 0
+			}
+			run_length++
+			analysis.LongestRun = max(analysis.LongestRun, run_length)
+
+			history = history<<8 | uint32(b)
+			analysis.Size++
+
+			if analysis.Size%PREDICTION_CHUNK_SIZE == 0 {
+				for _, prediction := range block_predictions {
+					prediction.add(chunk_counts, PREDICTION_CHUNK_SIZE)
+				}
+				clear(chunk_counts)
+			}
+
+			if analysis.Size >= REPEAT_SEQUENCE_LEN {
+				// Fibonacci hashing of the last sequence
+				hash := (history * 2654435769) >> (32 - REPEAT_HASH_BITS)
+				if seen_sequences[hash/64]&(1<<(hash%64)) != 0 {
+					analysis.RepeatedSequences++
+				}
+				seen_sequences[hash/64] |= 1 << (hash % 64)
+			}
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			return
+		}
+	}
+
+	counts := make(map[byte]int64)
+	for b, count := range analysis.Histogram {
+		if count > 0 {
+			counts[byte(b)] = count
+		}
+	}
+
+	tree := generateTreeFromCounts(counts)
+	encoding_table := tree.getEncodingTable()
+
+	for b, slice := range encoding_table {
+		if counts[b] > 0 {
+			analysis.CodeLengths[b] = slice.Len()
+		}
+	}
+
+	analysis.computeEntropy(context_counts, order2_counts)
+
+	// The stream ends with a CRC-32 trailer
+	const trailer_size = 9 + 9 + 4
+
+	for _, prediction := range block_predictions {
+		// The last block is not full, an empty input is encoded as one empty block
+		prediction.add(chunk_counts, analysis.Size%PREDICTION_CHUNK_SIZE)
+		if prediction.length > 0 || prediction.blocks == 0 {
+			prediction.finishBlock()
+		}
+
+		analysis.Predictions = append(analysis.Predictions, Prediction{
+			Method: "huffman",
+			Levels: strconv.Itoa(prediction.level),
+			Size:   prediction.size + trailer_size})
+	}
+
+	tree_bits, _ := bodyBits(counts, encoding_table)
+	analysis.Predictions = append(analysis.Predictions, Prediction{
+		Method: "huffman",
+		Levels: strconv.Itoa(DEFAULT_LEVEL),
+		Size:   tree.headerSize() + dataBlockSize(tree_bits) + trailer_size})
+
+	if analysis.Size > 0 {
+		_, header_size, context_bits := newContextTrees(context_counts, encoding_table)
+		analysis.Predictions = append(analysis.Predictions, Prediction{
+			Method: "huffman-order1",
+			Levels: "7-9",
+			Size:   header_size + dataBlockSize(context_bits) + trailer_size})
+	}
+	return
+}
+
+// Computes the empirical entropies from the byte counts per preceding byte and per two preceding bytes
+func (analysis *Analysis) computeEntropy(context_counts *contextCounts, order2_counts *order2Counts) {
+
+	if analysis.Size == 0 {
+		return
+	}
+
+	// Number of bits coding count bytes out of total takes
+	entropy := func(count, total int64) float64 {
+		return float64(count) * math.Log2(float64(total)/float64(count))
+	}
+
+	for _, count := range analysis.Histogram {
+		if count > 0 {
+			analysis.Order0Entropy += entropy(count, analysis.Size)
+		}
+	}
+	analysis.Order0Entropy /= float64(analysis.Size)
+
+	for context := range context_counts {
+		context_total := int64(0)
+		for _, count := range context_counts[context] {
+			context_total += count
+		}
+
+		for _, count := range context_counts[context] {
+			if count > 0 {
+				analysis.Order1Entropy += entropy(count, context_total)
+			}
+		}
+	}
+	analysis.Order1Entropy /= float64(analysis.Size)
+
+	if analysis.Size <= 2 {
+		return
+	}
+
+	for _, counts := range order2_counts {
+		if counts == nil {
+			continue
+		}
+
+		context_total := int64(0)
+		for _, count := range counts {
+			context_total += int64(count)
+		}
+
+		for _, count := range counts {
+			if count > 0 {
+				analysis.Order2Entropy += entropy(int64(count), context_total)
+			}
+		}
+	}
+	analysis.Order2Entropy /= float64(analysis.Size - 2)
+}
+
+// Byte counts per two preceding bytes. The counts of a context are only allocated once it occurs,
+// which bounds them to 64 MiB. Counts saturate, which only affects contexts occurring over 4G times.
+type order2Counts [1 << 16]*[256]uint32
+
+func (counts *order2Counts) add(context uint16, b byte) {
+	if counts[context] == nil {
+		counts[context] = &[256]uint32{}
+	}
+
+	if counts[context][b] != math.MaxUint32 {
+		counts[context][b]++
+	}
+}
+
+// Predicted size of the blocks of a level, which the input is split into
+type blockPrediction struct {
+	level  int
+	counts map[byte]int64
+	length int64
+	blocks int
+	size   int64
+}
+
+// Adds the counts of length bytes to the current block, which is finished once it is full
+func (prediction *blockPrediction) add(counts map[byte]int64, length int64) {
+
+	if prediction.counts == nil {
+		prediction.counts = make(map[byte]int64)
+	}
+
+	for b, count := range counts {
+		prediction.counts[b] += count
+	}
+	prediction.length += length
+
+	if prediction.length == level_block_sizes[prediction.level] {
+		prediction.finishBlock()
+	}
+}
+
+// Adds the size of the current block, encoded with a tree of its own
+func (prediction *blockPrediction) finishBlock() {
+
+	tree := generateTreeFromCounts(prediction.counts)
+	tree_bits, _ := bodyBits(prediction.counts, tree.getEncodingTable())
+	prediction.size += tree.headerSize() + dataBlockSize(tree_bits)
+
+	prediction.counts = nil
+	prediction.length = 0
+	prediction.blocks++
+}
@@ -0,0 +1,130 @@
+package huffman
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+
+	analysis, err := Analyze(strings.NewReader("aaaabbcd"))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if analysis.Size != 8 || analysis.Histogram['a'] != 4 || analysis.Histogram['d'] != 1 {
+		t.Errorf("Unexpected size %d or histogram %v", analysis.Size, analysis.Histogram)
+	}
+
+	// probabilities 1/2, 1/4, 1/8 and 1/8
+	if analysis.Order0Entropy != 1.75 {
+		t.Errorf("Expected order-0 entropy 1.75, got %f", analysis.Order0Entropy)
+	}
+
+	expected_lengths := map[byte]int{'a': 1, 'b': 2, 'c': 3, 'd': 3, 'e': 0}
+	for b, length := range expected_lengths {
+		if analysis.CodeLengths[b] != length {
+			t.Errorf("Expected code length %d for '%c', got %d", length, b, analysis.CodeLengths[b])
+		}
+	}
+
+	if analysis.Runs != 4 || analysis.LongestRun != 4 {
+		t.Errorf("Expected 4 runs of at most 4 bytes, got %d runs of at most %d bytes",
+			analysis.Runs, analysis.LongestRun)
+	}
+}
+
+func TestAnalyzeEntropy(t *testing.T) {
+
+	// every byte determines the next one
+	input := []byte(strings.Repeat("abcdefgh", 1000))
+
+	analysis, err := Analyze(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if analysis.Order0Entropy != 3 {
+		t.Errorf("Expected order-0 entropy 3, got %f", analysis.Order0Entropy)
+	}
+
+	if analysis.Order1Entropy > 0.01 || analysis.Order2Entropy != 0 {
+		t.Errorf("Expected order-1 and order-2 entropy near 0, got %f and %f",
+			analysis.Order1Entropy, analysis.Order2Entropy)
+	}
+
+	if analysis.RepeatedSequences != int64(len(input)-REPEAT_SEQUENCE_LEN+1-8) {
+		t.Errorf("Expected %d repeated sequences, got %d", len(input)-REPEAT_SEQUENCE_LEN+1-8,
+			analysis.RepeatedSequences)
+	}
+}
+
+func TestAnalyzePredictions(t *testing.T) {
+
+	inputs := map[string][]byte{
+		"empty": {},
+		"short": []byte("dense"),
+		"text":  []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 500))}
+
+	random := make([]byte, 10000)
+	for i := range random {
+		random[i] = byte(rand.Intn(256))
+	}
+	inputs["random"] = random
+
+	for name, input := range inputs {
+		analysis, err := Analyze(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: got error %s", name, err)
+		}
+
+		predicted_size := int64(math.MaxInt64)
+		for _, prediction := range analysis.Predictions {
+			predicted_size = min(predicted_size, prediction.Size)
+		}
+
+		// level 9 encodes one block with whichever order is smaller
+		var compressed bytes.Buffer
+		if err = EncodeWithOptions(bytes.NewReader(input), &compressed, LevelOptions(9)); err != nil {
+			t.Errorf("%s: got error %s", name, err)
+		}
+
+		if predicted_size != int64(compressed.Len()) {
+			t.Errorf("%s: predicted %d bytes, got %d", name, predicted_size, compressed.Len())
+		}
+	}
+}
+
+func TestAnalyzePredictionsBlocks(t *testing.T) {
+
+	// the byte distribution changes from block to block
+	input := make([]byte, 3*PREDICTION_CHUNK_SIZE+1000)
+	for i := range input {
+		input[i] = byte(rand.Intn(4 + i/(PREDICTION_CHUNK_SIZE/4)))
+	}
+
+	analysis, err := Analyze(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	for _, prediction := range analysis.Predictions {
+		level, err := strconv.Atoi(prediction.Levels)
+		if err != nil {
+			continue
+		}
+
+		var compressed bytes.Buffer
+		if err = EncodeWithOptions(bytes.NewReader(input), &compressed, LevelOptions(level)); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if prediction.Size != int64(compressed.Len()) {
+			t.Errorf("Level %d: predicted %d bytes, got %d", level, prediction.Size, compressed.Len())
+		}
+	}
+}
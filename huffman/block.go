@@ -0,0 +1,155 @@
+package huffman
+
+import (
+	"dense/bits"
+	"errors"
+	"io"
+)
+
+// The input of a block was different when it was read the second time
+var ErrInputChanged = errors.New("Input changed while encoding")
+
+// Size of a table block
+const TABLE_REF_SIZE = 9 + 4
+
+// Encodes everything the reader returned by open reads as a shape, leaves and data block.
+// If a table is passed and referencing it is smaller, a table and data block are written instead.
+// With context order 1, an order-1 block is written if that is smaller still.
+// If previous is not nil, the data block may reuse the trees of the previous block, coding with previous.
+// The input is opened twice, once to count the bytes and once to encode them, so it is not kept in memory.
+// Returns the number of bytes encoded and the encoding tables used.
+func encodeBlockFrom(open func() (io.Reader, error), writer io.Writer, table *Table, order int,
+	previous *byteTables) (size int64, tables *byteTables, err error) {
+
+	reader, err := open()
+	if err != nil {
+		return
+	}
+
+	context_counts := &contextCounts{}
+	if size, err = context_counts.countReader(reader); err != nil {
+		return
+	}
+
+	counts := context_counts.totals()
+
+	tree := generateTreeFromCounts(counts)
+	encoding_table := tree.getEncodingTable()
+
+	body_bits, _ := bodyBits(counts, encoding_table)
+	block_size := tree.headerSize() + dataBlockSize(body_bits)
+	encode_header := tree.encodeTree
+	tables = sameTables(encoding_table)
+
+	if table != nil {
+		table_bits, ok := bodyBits(counts, table.encoding_table)
+
+		if ok && TABLE_REF_SIZE+dataBlockSize(table_bits) <= block_size {
+			body_bits = table_bits
+			block_size = TABLE_REF_SIZE + dataBlockSize(table_bits)
+			encode_header = table.encodeTableRef
+			tables = sameTables(table.encoding_table)
+		}
+	}
+
+	if order == 1 && size > 0 {
+		contexts, header_size, context_bits := newContextTrees(context_counts, encoding_table)
+
+		if header_size+dataBlockSize(context_bits) < block_size {
+			body_bits = context_bits
+			block_size = header_size + dataBlockSize(context_bits)
+			encode_header = contexts.encodeHeader
+			tables = contexts.encodingTables()
+		}
+	}
+
+	if previous != nil {
+		previous_bits, ok := context_counts.bodyBits(previous)
+
+		if ok && dataBlockSize(previous_bits) <= block_size {
+			body_bits = previous_bits
+			encode_header = func(io.Writer) error { return nil }
+			tables = previous
+		}
+	}
+
+	if err = encode_header(writer); err != nil {
+		return
+	}
+
+	if reader, err = open(); err != nil {
+		return
+	}
+
+	body_size, err := encodeBodyStream(reader, writer, body_bits, tables)
+	if err == nil && body_size != size {
+		err = ErrInputChanged
+	}
+	return
+}
+
+// Returns the size of a data block with a body of body_bits bits
+func dataBlockSize(body_bits int64) int64 {
+	return 9 + 1 + (body_bits+7)/8
+}
+
+// Returns tables which use the same encoding table for every preceding byte
+func sameTables(encoding_table map[byte]bits.Slice) (tables *byteTables) {
+	tables = &byteTables{}
+	for context := range tables {
+		tables[context] = encoding_table
+	}
+	return
+}
+
+// Writes a data block with a body of body_bits bits, as counted beforehand.
+// Every byte read is coded with the table of the byte preceding it. Returns the number of bytes read.
+func encodeBodyStream(reader io.Reader, writer io.Writer, body_bits int64, tables *byteTables) (
+	size int64, err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_DATA, uint64(body_bits/8)); err != nil {
+		return
+	}
+
+	if _, err = writer.Write([]byte{byte(body_bits % 8)}); err != nil {
+		return
+	}
+
+	bits_writer := bits.NewWriter(writer)
+	buff := make([]byte, 1<<16)
+	previous := byte(0)
+	written_bits := int64(0)
+
+	for {
+		read_bytes, read_err := reader.Read(buff)
+
+		// a reader may return data along with an error
+		for _, b := range buff[:read_bytes] {
+			slice, ok := tables[previous][b]
+			if !ok {
+				return size, ErrInputChanged
+			}
+
+			if err = bits_writer.WriteSlice(&slice); err != nil {
+				return
+			}
+			written_bits += int64(slice.Len())
+			previous = b
+		}
+		size += int64(read_bytes)
+
+		if read_err != nil {
+			if read_err != io.EOF {
+				return size, read_err
+			}
+			break
+		}
+	}
+
+	if written_bits != body_bits {
+		return size, ErrInputChanged
+	}
+
+	err = bits_writer.Close()
+	return
+}
@@ -0,0 +1,33 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEncodeBlockInputChanged(t *testing.T) {
+
+	inputs := [][]byte{
+		[]byte("some content"),
+		[]byte("some other content"),
+		[]byte("some cntent"),
+		[]byte("some ontent")}
+
+	for _, changed := range inputs[1:] {
+		passes := 0
+		open := func() (io.Reader, error) {
+			passes++
+			if passes == 2 {
+				return bytes.NewReader(changed), nil
+			}
+			return bytes.NewReader(inputs[0]), nil
+		}
+
+		_, _, err := encodeBlockFrom(open, io.Discard, nil, 0, nil)
+		if !errors.Is(err, ErrInputChanged) {
+			t.Errorf("Expected ErrInputChanged for %q, got %v", changed, err)
+		}
+	}
+}
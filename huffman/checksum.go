@@ -0,0 +1,106 @@
+package huffman
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+const (
+	CHECKSUM_NONE  = 0
+	CHECKSUM_CRC32 = 1
+	CHECKSUM_CRC64 = 2
+)
+
+var crc64_table = crc64.MakeTable(crc64.ECMA)
+
+// Creates the hash for a checksum type
+func newChecksum(checksum_type byte) (checksum hash.Hash, err error) {
+	switch checksum_type {
+	case CHECKSUM_NONE:
+		checksum = noChecksum{}
+	case CHECKSUM_CRC32:
+		checksum = crc32.NewIEEE()
+	case CHECKSUM_CRC64:
+		checksum = crc64.New(crc64_table)
+	default:
+		err = ErrUnknownChecksum
+	}
+	return
+}
+
+// Returns the checksum in little endian byte order, as stored in the trailer
+func checksumDigest(checksum hash.Hash) (digest []byte) {
+	digest = checksum.Sum(nil)
+	for i, j := 0, len(digest)-1; i < j; i, j = i+1, j-1 {
+		digest[i], digest[j] = digest[j], digest[i]
+	}
+	return
+}
+
+// Hash for streams without a checksum
+type noChecksum struct{}
+
+func (noChecksum) Write(buff []byte) (int, error) { return len(buff), nil }
+func (noChecksum) Sum(buff []byte) []byte         { return buff }
+func (noChecksum) Reset()                         {}
+func (noChecksum) Size() int                      { return 0 }
+func (noChecksum) BlockSize() int                 { return 1 }
+
+// Writes the trailer, which ends the stream
+func encodeTrailer(writer io.Writer, checksum_type byte, size uint64, digest []byte) (err error) {
+
+	// checksum type, uncompressed size, checksum
+	trailer_buff := make([]byte, 9, 9+len(digest))
+	trailer_buff[0] = checksum_type
+	binary.LittleEndian.PutUint64(trailer_buff[1:9], size)
+	trailer_buff = append(trailer_buff, digest...)
+
+	if err = writeBlockHeader(writer, BLOCK_ID_TRAILER, uint64(len(trailer_buff))); err != nil {
+		return
+	}
+
+	_, err = writer.Write(trailer_buff)
+	return
+}
+
+func decodeTrailer(reader io.Reader) (checksum_type byte, size uint64, digest []byte, err error) {
+
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	trailer_len, err := readBlockHeader(reader, BLOCK_ID_TRAILER)
+	if err != nil {
+		return
+	}
+
+	checksum_type_buff := make([]byte, 1)
+	if _, err = io.ReadFull(reader, checksum_type_buff); err != nil {
+		return
+	}
+	checksum_type = checksum_type_buff[0]
+
+	checksum, err := newChecksum(checksum_type)
+	if err != nil {
+		return
+	}
+
+	if trailer_len != uint64(9+checksum.Size()) {
+		err = ErrInvalidTrailer
+		return
+	}
+
+	trailer_buff := make([]byte, 8+checksum.Size())
+	if _, err = io.ReadFull(reader, trailer_buff); err != nil {
+		return
+	}
+
+	size = binary.LittleEndian.Uint64(trailer_buff[:8])
+	digest = trailer_buff[8:]
+	return
+}
@@ -0,0 +1,138 @@
+package huffman
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChecksumDigest(t *testing.T) {
+
+	checksum, _ := newChecksum(CHECKSUM_CRC32)
+	checksum.Write([]byte("dense"))
+
+	digest := checksumDigest(checksum)
+	sum := checksum.(interface{ Sum32() uint32 }).Sum32()
+
+	expected := []byte{byte(sum), byte(sum >> 8), byte(sum >> 16), byte(sum >> 24)}
+	if !bytes.Equal(digest, expected) {
+		t.Errorf("Expected %v, got %v", expected, digest)
+	}
+
+	checksum, _ = newChecksum(CHECKSUM_NONE)
+	if len(checksumDigest(checksum)) != 0 {
+		t.Errorf("Expected empty digest, got %v", checksumDigest(checksum))
+	}
+
+	if _, err := newChecksum(0xFF); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestEncodeDecodeTrailer(t *testing.T) {
+
+	var buff bytes.Buffer
+
+	for _, checksum_type := range []byte{CHECKSUM_NONE, CHECKSUM_CRC32, CHECKSUM_CRC64} {
+
+		checksum, _ := newChecksum(checksum_type)
+		checksum.Write([]byte("dense"))
+		expected_digest := checksumDigest(checksum)
+
+		if err := encodeTrailer(&buff, checksum_type, 1234, expected_digest); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		decoded_type, size, digest, err := decodeTrailer(&buff)
+
+		if err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if decoded_type != checksum_type || size != 1234 || !bytes.Equal(digest, expected_digest) {
+			t.Errorf("Got type %d, size %d and checksum %v", decoded_type, size, digest)
+		}
+	}
+
+	// truncated trailer
+	encodeTrailer(&buff, CHECKSUM_CRC32, 1234, []byte{0xEF, 0xBE, 0xAD, 0xDE})
+	buff.Truncate(buff.Len() - 1)
+
+	if _, _, _, err := decodeTrailer(&buff); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	// checksum length not matching the type
+	buff.Reset()
+	encodeTrailer(&buff, CHECKSUM_CRC64, 1234, []byte{0xEF, 0xBE, 0xAD, 0xDE})
+
+	if _, _, _, err := decodeTrailer(&buff); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestDecodeChecksums(t *testing.T) {
+
+	for _, checksum_option := range []byte{CHECKSUM_OFF, CHECKSUM_CRC32, CHECKSUM_CRC64} {
+
+		options := DefaultOptions()
+		options.Checksum = checksum_option
+		checksum_type := options.checksumType()
+
+		var compressed, decompressed bytes.Buffer
+		if err := EncodeWithOptions(bytes.NewBufferString("dense"), &compressed, options); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		corrupted := append([]byte{}, compressed.Bytes()...)
+
+		if err := Decode(&compressed, &decompressed); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if decompressed.String() != "dense" {
+			t.Errorf("Expected 'dense', got '%s'", decompressed.String())
+		}
+
+		// flip a bit of the uncompressed size in the trailer
+		checksum, _ := newChecksum(checksum_type)
+		corrupted[len(corrupted)-1-checksum.Size()] ^= 0x1
+
+		if err := Decode(bytes.NewReader(corrupted), &decompressed); err != ErrChecksum {
+			t.Errorf("Expected '%s', got %v", ErrChecksum, err)
+		}
+	}
+}
+
+func TestDecodeWithoutTrailer(t *testing.T) {
+
+	// streams written before the trailer was introduced should still decode
+	var input, output, decoded bytes.Buffer
+	input.WriteString("legacy")
+
+	Encode(&input, &output)
+	legacy := output.Bytes()[:output.Len()-22]
+
+	if err := Decode(bytes.NewReader(legacy), &decoded); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if decoded.String() != "legacy" {
+		t.Errorf("Expected 'legacy', got '%s'", decoded.String())
+	}
+}
+
+func TestOptionsChecksum(t *testing.T) {
+
+	// the zero value writes the default checksum, CHECKSUM_OFF none
+	for checksum_option, expected := range map[byte]byte{0: DEFAULT_CHECKSUM, CHECKSUM_OFF: CHECKSUM_NONE} {
+		var compressed bytes.Buffer
+		if err := EncodeWithOptions(bytes.NewBufferString("dense"), &compressed, Options{Checksum: checksum_option}); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		info, err := List(&compressed)
+		if err != nil || info.HasChecksum != (expected != CHECKSUM_NONE) || info.HasChecksum && info.ChecksumType != expected {
+			t.Errorf("Expected checksum type %d, got %+v and error %v", expected, info, err)
+		}
+	}
+}
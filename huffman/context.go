@@ -0,0 +1,111 @@
+package huffman
+
+import (
+	"context"
+	"io"
+)
+
+// Minimal number of bytes between two progress reports of the decoder
+const PROGRESS_INTERVAL = 1 << 16
+
+// Called with the number of bytes read from the input and written to the output so far
+type ProgressFunc func(bytes_in, bytes_out int64)
+
+type DecodeOptions struct {
+	// Optional progress callback
+	Progress ProgressFunc
+
+	// Maximum number of decompressed bytes, unlimited if zero.
+	// Decoding fails with ErrOutputLimit when the stream would exceed it.
+	MaxOutput int64
+
+	// Tables which the stream may reference
+	Tables []*Table
+
+	// Dictionary the stream was compressed with, if any
+	Dictionary *Dictionary
+
+	// Key or passphrase an encrypted stream was encrypted with.
+	// If either is set, streams which are not encrypted are rejected.
+	Key        []byte
+	Passphrase string
+
+	// Stop after the trailer of the first member, without reading further.
+	// Otherwise concatenated streams are decoded one after another.
+	SingleMember bool
+
+	// If not nil, damaged blocks of streams written with Options.Recoverable are skipped instead of failing.
+	// Recover is called with the offset and size of the uncompressed data lost, or a size of -1 if
+	// the rest of a member is lost. The checksum of members with lost data is not verified.
+	Recover func(offset, size int64)
+}
+
+// Compresses until the input ends or the context is done
+func EncodeContext(ctx context.Context, reader io.Reader, writer io.Writer, options Options) (err error) {
+
+	compressor, err := NewWriterOptions(writer, options)
+	if err != nil {
+		return
+	}
+	compressor.ctx = ctx
+	defer compressor.removeSpill()
+
+	// Large inputs which can seek are read twice instead of being kept in memory
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		block_size := compressor.block_size
+		start, remaining := seekableRange(seeker)
+
+		if remaining > compressor.max_buffer && (block_size == 0 || block_size > compressor.max_buffer) {
+			compressor.encodeSeekable(seeker, start)
+			if compressor.err != nil {
+				return compressor.err
+			}
+			return compressor.Close()
+		}
+	}
+
+	if _, err = io.Copy(compressor, &contextReader{ctx: ctx, reader: reader}); err != nil {
+		return
+	}
+
+	err = compressor.Close()
+	return
+}
+
+// Returns the current offset and the number of bytes after it, or a negative number if the reader cannot seek
+func seekableRange(seeker io.Seeker) (start int64, remaining int64) {
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, -1
+	}
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, -1
+	}
+
+	if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+		return 0, -1
+	}
+	return start, end - start
+}
+
+// Decompresses until the stream ends or the context is done
+func DecodeContext(ctx context.Context, reader io.Reader, writer io.Writer, options DecodeOptions) (err error) {
+
+	return newDecoder(ctx, &contextReader{ctx: ctx, reader: reader}, writer, options, nil).decode()
+}
+
+// Reader which fails once its context is done
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader *contextReader) Read(buff []byte) (n int, err error) {
+	if err = reader.ctx.Err(); err != nil {
+		return
+	}
+	return reader.reader.Read(buff)
+}
@@ -0,0 +1,194 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEncodeContextCanceled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var output bytes.Buffer
+	err := EncodeContext(ctx, bytes.NewBufferString("dense"), &output, DefaultOptions())
+
+	if err != context.Canceled {
+		t.Errorf("Expected '%s', got %v", context.Canceled, err)
+	}
+}
+
+func TestEncodeContextCanceledBetweenBlocks(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	options := DefaultOptions()
+	options.BlockSize = 10
+	options.Progress = func(bytes_in, bytes_out int64) {
+		if bytes_out > 0 {
+			cancel()
+		}
+	}
+
+	compressor, err := NewWriterOptions(&bytes.Buffer{}, options)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+	compressor.ctx = ctx
+
+	compressor.Write(make([]byte, 10))
+	if _, err = compressor.Write(make([]byte, 10)); err != context.Canceled {
+		t.Errorf("Expected '%s', got %v", context.Canceled, err)
+	}
+}
+
+func TestDecodeContextCanceled(t *testing.T) {
+
+	var compressed, output bytes.Buffer
+	if err := Encode(bytes.NewBufferString("dense"), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := DecodeContext(ctx, &compressed, &output, DecodeOptions{})
+
+	if err != context.Canceled {
+		t.Errorf("Expected '%s', got %v", context.Canceled, err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+
+	input := bytes.Repeat([]byte("this is some content "), 20000)
+
+	var last_in, last_out int64
+	progress := func(bytes_in, bytes_out int64) {
+		if bytes_in < last_in || bytes_out < last_out {
+			t.Errorf("Progress went backwards: %d %d after %d %d", bytes_in, bytes_out, last_in, last_out)
+		}
+		last_in, last_out = bytes_in, bytes_out
+	}
+
+	options := DefaultOptions()
+	options.BlockSize = 100000
+	options.Progress = progress
+
+	var compressed, output bytes.Buffer
+	err := EncodeContext(context.Background(), bytes.NewReader(input), &compressed, options)
+
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if last_in != int64(len(input)) || last_out != int64(compressed.Len()) {
+		t.Errorf("Got final progress %d %d, expected %d %d", last_in, last_out, len(input), compressed.Len())
+	}
+
+	compressed_size := int64(compressed.Len())
+	last_in, last_out = 0, 0
+
+	err = DecodeContext(context.Background(), &compressed, &output, DecodeOptions{Progress: progress})
+
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if last_in != compressed_size || last_out != int64(len(input)) {
+		t.Errorf("Got final progress %d %d, expected %d %d", last_in, last_out, compressed_size, len(input))
+	}
+}
+
+func TestDecodeMaxOutput(t *testing.T) {
+
+	input := make([]byte, 1000)
+
+	var compressed bytes.Buffer
+	if err := Encode(bytes.NewReader(input), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	for _, max_output := range []int64{1, 999, 1000, 0} {
+
+		var output bytes.Buffer
+		options := DecodeOptions{
+			MaxOutput: max_output}
+
+		err := DecodeContext(context.Background(), bytes.NewReader(compressed.Bytes()), &output, options)
+
+		if max_output == 0 || max_output >= int64(len(input)) {
+			if err != nil {
+				t.Errorf("Limit %d: got error %s", max_output, err)
+			}
+			continue
+		}
+
+		if err != ErrOutputLimit {
+			t.Errorf("Limit %d: expected '%s', got %v", max_output, ErrOutputLimit, err)
+		}
+
+		if int64(output.Len()) > max_output {
+			t.Errorf("Limit %d: got %d bytes of output", max_output, output.Len())
+		}
+	}
+}
+
+func TestDecodeMembers(t *testing.T) {
+
+	inputs := []string{"first member", "", "third member, with another checksum"}
+	checksums := []byte{CHECKSUM_CRC32, CHECKSUM_OFF, CHECKSUM_CRC64}
+
+	var compressed bytes.Buffer
+	for i, input := range inputs {
+		options := DefaultOptions()
+		options.Checksum = checksums[i]
+
+		if err := EncodeWithOptions(bytes.NewBufferString(input), &compressed, options); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+	}
+
+	var output bytes.Buffer
+	if err := Decode(bytes.NewReader(compressed.Bytes()), &output); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if output.String() != inputs[0]+inputs[1]+inputs[2] {
+		t.Errorf("Expected all members, got '%s'", output.String())
+	}
+
+	info, err := List(bytes.NewReader(compressed.Bytes()))
+	if err != nil || info.Members != len(inputs) {
+		t.Errorf("Expected %d members, got %d and error %v", len(inputs), info.Members, err)
+	}
+
+	// the rest of the input is left unread after the first member
+	reader := bytes.NewReader(append(compressed.Bytes(), "rest"...))
+	output.Reset()
+	options := DecodeOptions{
+		SingleMember: true}
+
+	if err = DecodeContext(context.Background(), reader, &output, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if output.String() != inputs[0] {
+		t.Errorf("Expected '%s', got '%s'", inputs[0], output.String())
+	}
+
+	var first bytes.Buffer
+	Encode(bytes.NewBufferString(inputs[0]), &first)
+
+	if int(reader.Size())-reader.Len() != first.Len() {
+		t.Errorf("Expected %d bytes read, got %d", first.Len(), int(reader.Size())-reader.Len())
+	}
+
+	// data following a member must be another member
+	err = Decode(bytes.NewReader(append(first.Bytes(), "rest"...)), &output)
+	if !errors.Is(err, ErrNotDense) {
+		t.Errorf("Expected '%s', got %v", ErrNotDense, err)
+	}
+}
@@ -0,0 +1,731 @@
+package huffman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Number of decoded bytes buffered before they are written to the output
+const OUTPUT_BUFFER_SIZE = 64 << 10
+
+type decoder struct {
+	ctx        context.Context
+	progress   ProgressFunc
+	tables     map[uint32]*Table
+	dictionary *Dictionary
+	reader     *blockReader
+	writer     *countingWriter
+	output     io.Writer
+
+	// Buffer of the output of a data block, flushed at its end
+	buffered  *bufio.Writer
+	checksums map[byte]hash.Hash
+	info      *StreamInfo
+
+	// If not nil, weighted copies of the trees of every block are appended to it
+	trees *[]*HuffmanTree
+
+	// Trees of the previous data block, which a data block without trees reuses
+	previous bodyDecoder
+
+	// Input and output offset at which the current member starts
+	member_offset int64
+	member_output int64
+	single_member bool
+
+	// If not nil, called once with the header of the first member, or nil if it has none
+	header_func func(header *Header)
+
+	// Key or passphrase of encrypted members
+	key        []byte
+	passphrase string
+
+	// Header of the current member, and the reader of its sealed blocks if it is encrypted
+	member_header *Header
+	opener        *openReader
+
+	// Reader correcting the frames of the current member, if it has parity
+	parity_reader *parityReader
+
+	// If not nil, damaged blocks following a resync block are skipped and reported to it
+	recover func(offset, size int64)
+
+	// Marker of the block being recovered, whose output is buffered until it is verified
+	resync          *resyncMarker
+	resync_buff     *blockBuffer
+	resync_seen     bool
+	resync_final    bool
+	verified_output io.Writer
+
+	// Uncompressed offset of the current member, the size of it recovered and lost so far
+	member_start int64
+	recovered    int64
+	member_lost  bool
+	lost_size    int64
+}
+
+// Creates a decoder, which records the blocks it reads in info if it is not nil
+func newDecoder(ctx context.Context, reader io.Reader, writer io.Writer, options DecodeOptions,
+	info *StreamInfo) *decoder {
+
+	// The checksum type is only known at the end of the stream, so all of them are computed
+	checksums := map[byte]hash.Hash{
+		CHECKSUM_NONE:  noChecksum{},
+		CHECKSUM_CRC32: crc32.NewIEEE()}
+	checksums[CHECKSUM_CRC64], _ = newChecksum(CHECKSUM_CRC64)
+
+	counting_writer := &countingWriter{
+		writer: writer,
+		limit:  options.MaxOutput}
+
+	tables := make(map[uint32]*Table, len(options.Tables))
+	for _, table := range options.Tables {
+		tables[table.id] = table
+	}
+
+	output_writers := []io.Writer{counting_writer}
+	for _, checksum := range checksums {
+		output_writers = append(output_writers, checksum)
+	}
+
+	output := io.MultiWriter(output_writers...)
+
+	decoder := &decoder{
+		ctx:        ctx,
+		progress:   options.Progress,
+		tables:     tables,
+		dictionary: options.Dictionary,
+		reader:     &blockReader{reader: reader},
+		writer:     counting_writer,
+		output:     output,
+		buffered:   bufio.NewWriterSize(output, OUTPUT_BUFFER_SIZE),
+		checksums:  checksums,
+		info:       info,
+
+		single_member:   options.SingleMember,
+		key:             options.Key,
+		passphrase:      options.Passphrase,
+		recover:         options.Recover,
+		verified_output: output}
+
+	counting_writer.progress = decoder.reportProgress
+	return decoder
+}
+
+// Decodes members until the input ends, or only the first one
+func (decoder *decoder) decode() (err error) {
+
+	defer decoder.reportProgress()
+
+	for {
+		decoder.info.addMember()
+
+		trailer, err := decoder.decodeMember()
+		if err != nil || !trailer || decoder.single_member {
+			return err
+		}
+
+		// Concatenated streams are decoded one after another, like gzip members
+		if _, err = decoder.reader.peekBlockID(); err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+		decoder.startMember()
+	}
+}
+
+// Resets the state of the decoder for the next member
+func (decoder *decoder) startMember() {
+
+	for _, checksum := range decoder.checksums {
+		checksum.Reset()
+	}
+
+	decoder.previous = nil
+	decoder.member_header = nil
+	decoder.member_offset = decoder.reader.offset()
+	decoder.member_output = decoder.writer.count
+
+	decoder.resync_seen = false
+	decoder.resync_final = false
+	decoder.member_start = decoder.writer.count + decoder.lost_size
+	decoder.recovered = 0
+	decoder.member_lost = false
+}
+
+// Decodes blocks until the trailer. Trailer is false for a stream ending without trailer.
+// When recovering, decoding goes on at the next resync block after a damaged block.
+func (decoder *decoder) decodeMember() (trailer bool, err error) {
+
+	resumed := false
+	for {
+		trailer, err = decoder.decodeBlocks(resumed)
+
+		if err != nil && decoder.canRecover(err) {
+			if err = decoder.skipDamaged(); err == io.EOF {
+				return false, nil
+			}
+			if err == nil {
+				resumed = true
+				continue
+			}
+		}
+
+		return
+	}
+}
+
+// Decodes blocks until the trailer, after a resync block if resumed
+func (decoder *decoder) decodeBlocks(resumed bool) (trailer bool, err error) {
+
+	block_count := 0
+	if resumed {
+		block_count = 1
+	}
+
+	// Whether the member only has the blocks streams without trailer had
+	legacy := !resumed
+
+	// Offset of the first block after the header, where the dictionary block may be
+	start_offset := decoder.member_offset
+
+	for {
+		if err = decoder.ctx.Err(); err != nil {
+			return
+		}
+
+		offset := decoder.reader.offset()
+		block_id, err := decoder.reader.peekBlockID()
+
+		// A stream without header starts with any other known block
+		if err == nil && block_id != BLOCK_ID_HEADER && block_id != BLOCK_ID_PARITY && BlockName(block_id) != "unknown" {
+			decoder.reportHeader(nil)
+		}
+
+		if err == io.EOF {
+			if block_count == 0 {
+				return false, newFormatError(io.ErrUnexpectedEOF, BLOCK_ID_SHAPE, offset)
+			}
+
+			// Encrypted members and members with parity always end with a trailer
+			if decoder.opener != nil {
+				return false, newFormatError(io.ErrUnexpectedEOF, BLOCK_ID_SEALED, offset)
+			}
+			if decoder.parity_reader != nil {
+				return false, newFormatError(io.ErrUnexpectedEOF, BLOCK_ID_TRAILER, offset)
+			}
+
+			// Streams written before the trailer was introduced are a single shape, leaves and data block
+			if legacy && block_count == 1 {
+				return false, nil
+			}
+			return false, newFormatError(io.ErrUnexpectedEOF, BLOCK_ID_TRAILER, offset)
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if block_id != BLOCK_ID_SHAPE {
+			legacy = false
+		}
+
+		// With a key, members which are not encrypted are rejected
+		encrypting := decoder.key != nil || decoder.passphrase != ""
+		if encrypting && decoder.opener == nil && block_id != BLOCK_ID_HEADER && block_id != BLOCK_ID_ENCRYPTION &&
+			block_id != BLOCK_ID_PARITY && BlockName(block_id) != "unknown" {
+			return false, ErrNotEncrypted
+		}
+
+		switch block_id {
+		case BLOCK_ID_SHAPE:
+			if err = decoder.decodeBlock(); err != nil {
+				return false, err
+			}
+			block_count++
+		case BLOCK_ID_TABLE:
+			if err = decoder.decodeTableBlock(); err != nil {
+				return false, err
+			}
+			block_count++
+		case BLOCK_ID_CONTEXT:
+			if err = decoder.decodeContextBlock(); err != nil {
+				return false, err
+			}
+			block_count++
+		case BLOCK_ID_DATA:
+			// A data block without trees reuses those of the previous data block
+			if decoder.previous == nil {
+				if block_count == 0 {
+					return false, newFormatError(ErrNotDense, block_id, offset)
+				}
+				return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+			}
+			if err = decoder.decodeDataBlock(decoder.previous); err != nil {
+				return false, err
+			}
+			block_count++
+		case BLOCK_ID_RESYNC:
+			if err = decoder.decodeResyncBlock(); err != nil {
+				return false, err
+			}
+		case BLOCK_ID_SYNC:
+			if err = decoder.decodeSyncBlock(); err != nil {
+				return false, err
+			}
+		case BLOCK_ID_PARITY:
+			// The parity block precedes every other block of a member
+			if offset != decoder.member_offset || decoder.parity_reader != nil {
+				return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+			}
+			if err = decoder.decodeParityBlock(); err != nil {
+				return false, err
+			}
+			decoder.member_offset = decoder.reader.offset()
+			start_offset = decoder.member_offset
+		case BLOCK_ID_HEADER:
+			// Only the first block of a member may be a header block
+			if offset != decoder.member_offset {
+				return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+			}
+			if err = decoder.decodeHeaderBlock(); err != nil {
+				return false, err
+			}
+			start_offset = decoder.reader.offset()
+		case BLOCK_ID_ENCRYPTION:
+			// The encryption block follows the header, if any
+			if offset != start_offset || decoder.opener != nil {
+				return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+			}
+			if err = decoder.decodeEncryptionBlock(); err != nil {
+				return false, err
+			}
+			start_offset = decoder.reader.offset()
+		case BLOCK_ID_DICTIONARY:
+			// Only the first block of a member after the header may be a dictionary block
+			if offset != start_offset {
+				return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+			}
+			if err = decoder.decodeDictionaryBlock(); err != nil {
+				return false, err
+			}
+		case BLOCK_ID_TRAILER:
+			if block_count == 0 {
+				return false, newFormatError(ErrNotDense, block_id, offset)
+			}
+			if err = decoder.decodeTrailer(); err != nil {
+				return false, err
+			}
+			if err = decoder.endEncryption(); err != nil {
+				return false, err
+			}
+			return true, decoder.endParity()
+		default:
+			if block_count == 0 {
+				return false, newFormatError(ErrNotDense, block_id, offset)
+			}
+			return false, newFormatError(ErrUnexpectedBlock, block_id, offset)
+		}
+	}
+}
+
+// Decodes a shape, leaves and data block
+func (decoder *decoder) decodeBlock() (err error) {
+
+	tree, err := decoder.decodeTree()
+	if err != nil {
+		return
+	}
+
+	return decoder.decodeDataBlock(tree)
+}
+
+// Decodes a shape and leaves block
+func (decoder *decoder) decodeTree() (tree *HuffmanTree, err error) {
+
+	block_id := byte(BLOCK_ID_SHAPE)
+	offset := decoder.reader.offset()
+
+	defer func() {
+		// the block ID was peeked already, so the stream ends within the block
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		err = newFormatError(err, block_id, offset)
+	}()
+
+	tree, err = decodeTreeShape(decoder.reader)
+	if err != nil {
+		return
+	}
+	decoder.info.addBlock(BLOCK_ID_SHAPE, offset, decoder.reader.offset(), 0)
+
+	block_id = BLOCK_ID_LEAVES
+	offset = decoder.reader.offset()
+	if err = tree.decodeTreeLeaves(decoder.reader); err != nil {
+		return
+	}
+	decoder.info.addBlock(BLOCK_ID_LEAVES, offset, decoder.reader.offset(), 0)
+	return
+}
+
+// Decodes a table and data block
+func (decoder *decoder) decodeTableBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	id, err := decodeTableRef(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_TABLE, offset)
+	}
+
+	table, ok := decoder.tables[id]
+	if !ok {
+		return unknownTableError(id)
+	}
+	decoder.info.addBlock(BLOCK_ID_TABLE, offset, decoder.reader.offset(), 0)
+
+	return decoder.decodeDataBlock(table.tree)
+}
+
+// Decodes a context block, the trees it refers to and a data block
+func (decoder *decoder) decodeContextBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	contexts := &contextTrees{}
+
+	tree_count, err := contexts.decodeIndexes(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_CONTEXT, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_CONTEXT, offset, decoder.reader.offset(), 0)
+
+	for len(contexts.trees) < tree_count {
+		tree, err := decoder.decodeTree()
+		if err != nil {
+			return err
+		}
+		contexts.trees = append(contexts.trees, tree)
+	}
+
+	return decoder.decodeDataBlock(contexts)
+}
+
+// Decodes the dictionary block and checks the stream was compressed with the dictionary of the decoder
+func (decoder *decoder) decodeDictionaryBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	id, err := decodeDictionaryRef(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_DICTIONARY, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_DICTIONARY, offset, decoder.reader.offset(), 0)
+	decoder.info.setDictionary(id)
+
+	if decoder.dictionary == nil || decoder.dictionary.id != id {
+		return unknownDictionaryError(id)
+	}
+
+	table := decoder.dictionary.table
+	decoder.tables[table.id] = table
+	return
+}
+
+// Tree or trees a data block is coded with
+type bodyDecoder interface {
+	decodeBody(reader io.Reader, emit func(byte) error) error
+}
+
+// Decodes a data block which follows the trees it was encoded with
+func (decoder *decoder) decodeDataBlock(trees bodyDecoder) (err error) {
+
+	offset := decoder.reader.offset()
+	output_offset := decoder.writer.count
+
+	// Writing every byte to the output and all checksums would be slow
+	decoder.buffered.Reset(decoder.output)
+	emit := decoder.buffered.WriteByte
+
+	var counts *contextCounts
+	if decoder.trees != nil {
+		counts = &contextCounts{}
+		write := emit
+		previous := byte(0)
+
+		emit = func(b byte) error {
+			counts[previous][b]++
+			previous = b
+			return write(b)
+		}
+	}
+
+	// What was decoded is written even if the block turns out to be invalid
+	err = trees.decodeBody(decoder.reader, emit)
+	flush_err := decoder.buffered.Flush()
+
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_DATA, offset)
+	}
+
+	if flush_err != nil {
+		return flush_err
+	}
+
+	decoder.info.addBlock(BLOCK_ID_DATA, offset, decoder.reader.offset(),
+		decoder.writer.count-output_offset)
+	decoder.previous = trees
+
+	if decoder.trees != nil {
+		*decoder.trees = append(*decoder.trees, weightedTrees(trees, counts)...)
+	}
+	return
+}
+
+// Decodes a header block and reports it
+func (decoder *decoder) decodeHeaderBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	header, err := decodeHeader(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_HEADER, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_HEADER, offset, decoder.reader.offset(), 0)
+	decoder.member_header = header
+	decoder.reportHeader(header)
+	return
+}
+
+// Decodes an encryption block, after which blocks are read from the sealed blocks following it
+func (decoder *decoder) decodeEncryptionBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	params, err := decodeEncryption(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_ENCRYPTION, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_ENCRYPTION, offset, decoder.reader.offset(), 0)
+	decoder.info.setEncrypted()
+
+	opener := &openReader{reader: decoder.reader.reader}
+	if opener.aead, err = params.newAEAD(decoder.key, decoder.passphrase); err != nil {
+		return
+	}
+
+	if opener.prefix, err = encryptionPrefix(decoder.member_header, params); err != nil {
+		return
+	}
+
+	decoder.opener = opener
+	decoder.reader.reader = opener
+	return
+}
+
+// Checks that the last sealed block of an encrypted member ends with the trailer and reads on from the input
+func (decoder *decoder) endEncryption() (err error) {
+
+	if decoder.opener == nil {
+		return
+	}
+
+	offset := decoder.reader.offset()
+	if err = decoder.opener.end(); err != nil {
+		return newFormatError(err, BLOCK_ID_SEALED, offset)
+	}
+
+	decoder.reader.reader = decoder.opener.reader
+	decoder.opener = nil
+	return
+}
+
+// Decodes a parity block, after which blocks are read from the corrected frames following it
+func (decoder *decoder) decodeParityBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	params, err := decodeParity(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_PARITY, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_PARITY, offset, decoder.reader.offset(), 0)
+
+	decoder.parity_reader = newParityReader(decoder.reader.reader, params)
+	decoder.reader.reader = decoder.parity_reader
+	return
+}
+
+// Checks that the last frame of a member with parity ends with the trailer and reads on from the input
+func (decoder *decoder) endParity() (err error) {
+
+	if decoder.parity_reader == nil {
+		return
+	}
+
+	offset := decoder.reader.offset()
+	err = decoder.parity_reader.end()
+	decoder.info.addCorrected(decoder.parity_reader.corrected)
+	if err != nil {
+		return newFormatError(err, BLOCK_ID_PARITY, offset)
+	}
+
+	decoder.reader.reader = decoder.parity_reader.reader
+	decoder.parity_reader = nil
+	return
+}
+
+// Passes the header of the first member to header_func, only the first call has an effect
+func (decoder *decoder) reportHeader(header *Header) {
+	decoder.info.setHeader(header)
+
+	if decoder.header_func != nil {
+		decoder.header_func(header)
+		decoder.header_func = nil
+	}
+}
+
+// Decodes a sync block and flushes the output, if it has a Flush method
+func (decoder *decoder) decodeSyncBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	if err = decodeSync(decoder.reader); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_SYNC, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_SYNC, offset, decoder.reader.offset(), 0)
+
+	if err = decoder.finishResync(); err != nil {
+		return
+	}
+
+	if flusher, ok := decoder.writer.writer.(interface{ Flush() error }); ok {
+		err = flusher.Flush()
+	}
+	return
+}
+
+// Decodes the trailer and verifies the size and checksum of the output
+func (decoder *decoder) decodeTrailer() (err error) {
+
+	offset := decoder.reader.offset()
+	checksum_type, size, digest, err := decodeTrailer(decoder.reader)
+	if err != nil {
+		return newFormatError(err, BLOCK_ID_TRAILER, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_TRAILER, offset, decoder.reader.offset(), 0)
+	decoder.info.setTrailer(checksum_type, digest)
+
+	// Recovered members miss the data that was lost
+	if decoder.member_lost {
+		return
+	}
+
+	if size != uint64(decoder.writer.count-decoder.member_output) ||
+		!bytes.Equal(digest, checksumDigest(decoder.checksums[checksum_type])) {
+		err = ErrChecksum
+	}
+	return
+}
+
+func (decoder *decoder) reportProgress() {
+	if decoder.progress != nil {
+		decoder.progress(decoder.reader.offset(), decoder.writer.count)
+	}
+}
+
+// Reader which counts the bytes read and allows peeking at the next block ID
+type blockReader struct {
+	reader io.Reader
+	count  int64
+	peeked []byte
+}
+
+func (reader *blockReader) Read(buff []byte) (n int, err error) {
+	if len(reader.peeked) > 0 && len(buff) > 0 {
+		n = copy(buff, reader.peeked)
+		reader.peeked = reader.peeked[n:]
+		return
+	}
+
+	n, err = reader.reader.Read(buff)
+	reader.count += int64(n)
+	return
+}
+
+// Reads the next block ID without consuming it
+func (reader *blockReader) peekBlockID() (block_id byte, err error) {
+	if len(reader.peeked) == 0 {
+		block_id_buff := make([]byte, 1)
+		if _, err = io.ReadFull(reader.reader, block_id_buff); err != nil {
+			return
+		}
+		reader.count++
+		reader.peeked = block_id_buff
+	}
+
+	block_id = reader.peeked[0]
+	return
+}
+
+// Returns the offset of the next byte to be read
+func (reader *blockReader) offset() int64 {
+	return reader.count - int64(len(reader.peeked))
+}
+
+// Writer which counts the bytes written, optionally reports progress and enforces a size limit
+type countingWriter struct {
+	writer        io.Writer
+	count         int64
+	limit         int64
+	progress      func()
+	last_progress int64
+}
+
+func (writer *countingWriter) Write(buff []byte) (n int, err error) {
+
+	// Writes up to the limit before failing
+	limited := false
+	if writer.limit > 0 && writer.count+int64(len(buff)) > writer.limit {
+		buff = buff[:writer.limit-writer.count]
+		limited = true
+	}
+
+	n, err = writer.writer.Write(buff)
+	writer.count += int64(n)
+
+	if err == nil && limited {
+		err = ErrOutputLimit
+	}
+
+	if writer.progress != nil && writer.count-writer.last_progress >= PROGRESS_INTERVAL {
+		writer.last_progress = writer.count
+		writer.progress()
+	}
+	return
+}
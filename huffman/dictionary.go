@@ -0,0 +1,114 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Start of every dictionary file
+const DICTIONARY_MAGIC = "DDCT"
+
+var (
+	ErrInvalidDictionary    = errors.New("Invalid dictionary file")
+	ErrInvalidDictionaryRef = errors.New("Invalid dictionary reference")
+	ErrUnknownDictionary    = errors.New("Unknown dictionary")
+)
+
+// Symbol statistics trained on a set of samples.
+// A stream compressed with a dictionary starts with a dictionary block holding its ID
+// and can only be decompressed with the same dictionary.
+//
+// Unlike zstd dictionaries, it has no content section: dense has no LZ stage, so there is no
+// match window to prime. It is a Table recorded under its own ID.
+type Dictionary struct {
+	id    uint32
+	table *Table
+}
+
+// Builds a dictionary from sample files. All samples are weighted by their size.
+func TrainDictionary(samples ...io.Reader) (dictionary *Dictionary, err error) {
+
+	table, err := TrainTable(io.MultiReader(samples...))
+	if err != nil {
+		return
+	}
+
+	dictionary, err = newDictionary(table)
+	return
+}
+
+// Reads a dictionary file as written by WriteTo
+func ReadDictionary(reader io.Reader) (dictionary *Dictionary, err error) {
+
+	tree, err := readTreeFile(reader, DICTIONARY_MAGIC, ErrInvalidDictionary)
+	if err != nil {
+		return
+	}
+
+	table, err := newTable(tree)
+	if err != nil {
+		return
+	}
+
+	dictionary, err = newDictionary(table)
+	return
+}
+
+// Creates a dictionary, its ID is the CRC-32 of the dictionary file
+func newDictionary(table *Table) (dictionary *Dictionary, err error) {
+
+	dictionary = &Dictionary{
+		table: table}
+
+	var dictionary_buff bytes.Buffer
+	if _, err = dictionary.WriteTo(&dictionary_buff); err != nil {
+		return
+	}
+
+	dictionary.id = crc32.ChecksumIEEE(dictionary_buff.Bytes())
+	return
+}
+
+// Returns the ID recorded in streams compressed with the dictionary
+func (dictionary *Dictionary) ID() uint32 {
+	return dictionary.id
+}
+
+// Returns the table which the blocks of streams compressed with the dictionary reference
+func (dictionary *Dictionary) Table() *Table {
+	return dictionary.table
+}
+
+// Writes the dictionary file
+func (dictionary *Dictionary) WriteTo(writer io.Writer) (n int64, err error) {
+
+	var dictionary_buff bytes.Buffer
+	dictionary_buff.WriteString(DICTIONARY_MAGIC)
+
+	if err = dictionary.table.tree.encodeTree(&dictionary_buff); err != nil {
+		return
+	}
+
+	return dictionary_buff.WriteTo(writer)
+}
+
+// Writes the dictionary block, which starts the stream
+func (dictionary *Dictionary) encodeDictionaryRef(writer io.Writer) (err error) {
+	return writeIDBlock(writer, BLOCK_ID_DICTIONARY, dictionary.id)
+}
+
+func decodeDictionaryRef(reader io.Reader) (id uint32, err error) {
+	id, err = readIDBlock(reader, BLOCK_ID_DICTIONARY)
+	if err == errInvalidIDBlock {
+		err = ErrInvalidDictionaryRef
+	}
+	return
+}
+
+// Returns the error for a stream compressed with another dictionary than the one passed to the decoder
+func unknownDictionaryError(id uint32) error {
+	return fmt.Errorf("%w %08x", ErrUnknownDictionary, id)
+}
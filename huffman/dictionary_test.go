@@ -0,0 +1,131 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDictionaryWriteRead(t *testing.T) {
+
+	dictionary, err := TrainDictionary(bytes.NewBufferString("first sample"),
+		bytes.NewBufferString("second sample"))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	var buff bytes.Buffer
+	if _, err = dictionary.WriteTo(&buff); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	read_dictionary, err := ReadDictionary(&buff)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if read_dictionary.ID() != dictionary.ID() {
+		t.Errorf("Expected ID %08x, got %08x", dictionary.ID(), read_dictionary.ID())
+	}
+
+	if dictionary.ID() == dictionary.Table().ID() {
+		t.Errorf("Expected dictionary and table IDs to differ")
+	}
+
+	if _, err = ReadDictionary(bytes.NewBufferString("not a dictionary")); err != ErrInvalidDictionary {
+		t.Errorf("Expected '%s', got %v", ErrInvalidDictionary, err)
+	}
+
+	// codes too long to encode with
+	buff.Reset()
+	buff.WriteString(DICTIONARY_MAGIC)
+	chainTree(MAX_CODE_LEN + 2).encodeTree(&buff)
+
+	if _, err = ReadDictionary(&buff); err != ErrInvalidDictionary {
+		t.Errorf("Expected '%s', got %v", ErrInvalidDictionary, err)
+	}
+}
+
+func TestEncodeDecodeDictionary(t *testing.T) {
+
+	samples := []string{
+		`{"id": 1, "name": "first", "tags": ["a"]}`,
+		`{"id": 2, "name": "second", "tags": ["b", "c"]}`}
+
+	dictionary, _ := TrainDictionary(bytes.NewBufferString(samples[0]), bytes.NewBufferString(samples[1]))
+	other_dictionary, _ := TrainDictionary(bytes.NewBufferString("unrelated"))
+
+	message := []byte(`{"id": 3, "name": "third", "tags": []}`)
+
+	options := DefaultOptions()
+	options.Dictionary = dictionary
+
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(message), &compressed, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, err := ListWithOptions(bytes.NewReader(compressed.Bytes()), DecodeOptions{Dictionary: dictionary})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if info.Blocks[0].ID != BLOCK_ID_DICTIONARY || info.Blocks[1].ID != BLOCK_ID_TABLE {
+		t.Errorf("Expected dictionary and table block, got %+v", info.Blocks)
+	}
+
+	if !info.HasDictionary || info.DictionaryID != dictionary.ID() {
+		t.Errorf("Expected dictionary %08x, got %+v", dictionary.ID(), info)
+	}
+
+	for _, options := range []DecodeOptions{{}, {Dictionary: other_dictionary}} {
+		err = DecodeContext(context.Background(), bytes.NewReader(compressed.Bytes()), &bytes.Buffer{}, options)
+		if !errors.Is(err, ErrUnknownDictionary) {
+			t.Errorf("Expected '%s', got %v", ErrUnknownDictionary, err)
+		}
+	}
+
+	var output bytes.Buffer
+	err = DecodeContext(context.Background(), &compressed, &output, DecodeOptions{Dictionary: dictionary})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !bytes.Equal(message, output.Bytes()) {
+		t.Errorf("Expected '%s', got '%s'", message, output.Bytes())
+	}
+}
+
+func TestDecodeMisplacedDictionary(t *testing.T) {
+
+	dictionary, _ := TrainDictionary(bytes.NewBufferString("sample"))
+
+	// a dictionary block after the first block
+	var stream bytes.Buffer
+	open := func() (io.Reader, error) {
+		return bytes.NewBufferString("content"), nil
+	}
+	encodeBlockFrom(open, &stream, nil, 0, nil)
+	dictionary.encodeDictionaryRef(&stream)
+	offset := int64(stream.Len()) - 13
+
+	err := DecodeContext(context.Background(), &stream, &bytes.Buffer{}, DecodeOptions{Dictionary: dictionary})
+
+	var format_err *FormatError
+	if !errors.As(err, &format_err) || format_err.Offset != offset || format_err.Err != ErrUnexpectedBlock {
+		t.Errorf("Expected '%s' at offset %d, got %v", ErrUnexpectedBlock, offset, err)
+	}
+}
+
+func TestOptionsTableAndDictionary(t *testing.T) {
+
+	options := DefaultOptions()
+	options.Table, _ = TrainTable(bytes.NewBufferString("sample"))
+	options.Dictionary, _ = TrainDictionary(bytes.NewBufferString("sample"))
+
+	if _, err := NewWriterOptions(&bytes.Buffer{}, options); err == nil {
+		t.Errorf("Expected error")
+	}
+}
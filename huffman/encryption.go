@@ -0,0 +1,325 @@
+package huffman
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Cipher of an encrypted stream
+const ENCRYPTION_AES_256_GCM = 1
+
+// Derivation of the stream key, from a key with HKDF or from a passphrase with PBKDF2
+const (
+	KDF_HKDF_SHA256   = 1
+	KDF_PBKDF2_SHA256 = 2
+)
+
+const (
+	// Length of keys passed in the options
+	KEY_SIZE = 32
+
+	// Length of the random salt of every encrypted stream
+	SALT_SIZE = 16
+
+	// Length of the encryption block body: cipher, key derivation, iterations and salt
+	ENCRYPTION_LEN = 1 + 1 + 4 + SALT_SIZE
+
+	// Maximum number of plaintext bytes per sealed block
+	SEAL_SIZE = 64 << 10
+
+	// Maximum number of PBKDF2 iterations a stream may ask for
+	MAX_PBKDF2_ITERATIONS = 1 << 24
+)
+
+// Number of PBKDF2 iterations of new streams, a variable so tests can lower it
+var pbkdf2_iterations = 600000
+
+var (
+	ErrInvalidEncryption = errors.New("Invalid encryption block")
+	ErrInvalidSealed     = errors.New("Invalid sealed block")
+	ErrAuthentication    = errors.New("Authentication failed, the stream was modified or the key is wrong")
+	ErrKeyRequired       = errors.New("Stream is encrypted, a key or passphrase is required")
+	ErrNotEncrypted      = errors.New("Stream is not encrypted")
+	ErrInvalidKey        = errors.New("Key must be 32 bytes")
+)
+
+// Parameters of an encrypted stream, stored in the encryption block
+type encryption struct {
+	cipher     byte
+	kdf        byte
+	iterations uint32
+	salt       [SALT_SIZE]byte
+}
+
+// Creates the parameters of a new stream, encrypted with a key or a passphrase
+func newEncryption(key []byte, passphrase string) (params *encryption, err error) {
+
+	params = &encryption{
+		cipher: ENCRYPTION_AES_256_GCM,
+		kdf:    KDF_HKDF_SHA256}
+
+	if key == nil {
+		params.kdf = KDF_PBKDF2_SHA256
+		params.iterations = uint32(pbkdf2_iterations)
+	}
+
+	_, err = rand.Read(params.salt[:])
+	return
+}
+
+func (params *encryption) encodeEncryption(writer io.Writer) (err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_ENCRYPTION, ENCRYPTION_LEN); err != nil {
+		return
+	}
+
+	body := []byte{params.cipher, params.kdf}
+	body = binary.LittleEndian.AppendUint32(body, params.iterations)
+	body = append(body, params.salt[:]...)
+
+	_, err = writer.Write(body)
+	return
+}
+
+func decodeEncryption(reader io.Reader) (params *encryption, err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_ENCRYPTION)
+	if err != nil {
+		return
+	}
+
+	if length != ENCRYPTION_LEN {
+		err = ErrInvalidEncryption
+		return
+	}
+
+	body := make([]byte, ENCRYPTION_LEN)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	params = &encryption{
+		cipher:     body[0],
+		kdf:        body[1],
+		iterations: binary.LittleEndian.Uint32(body[2:])}
+	copy(params.salt[:], body[6:])
+
+	valid_kdf := (params.kdf == KDF_HKDF_SHA256 && params.iterations == 0) ||
+		(params.kdf == KDF_PBKDF2_SHA256 && params.iterations > 0 && params.iterations <= MAX_PBKDF2_ITERATIONS)
+
+	if params.cipher != ENCRYPTION_AES_256_GCM || !valid_kdf {
+		params = nil
+		err = ErrInvalidEncryption
+	}
+	return
+}
+
+// Derives the key of the stream and returns its cipher
+func (params *encryption) newAEAD(key []byte, passphrase string) (aead cipher.AEAD, err error) {
+
+	var stream_key []byte
+
+	switch params.kdf {
+	case KDF_HKDF_SHA256:
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+		stream_key, err = hkdf.Key(sha256.New, key, params.salt[:], "dense aes-256-gcm", KEY_SIZE)
+	case KDF_PBKDF2_SHA256:
+		if passphrase == "" {
+			return nil, ErrKeyRequired
+		}
+		stream_key, err = pbkdf2.Key(sha256.New, passphrase, params.salt[:], int(params.iterations), KEY_SIZE)
+	}
+
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(stream_key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the nonce of the sealed block with the given index
+func sealNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+// Returns the additional data of a sealed block: the header and encryption blocks,
+// and whether it is the last sealed block of the stream
+func sealData(prefix []byte, final bool) []byte {
+	data := append([]byte{}, prefix...)
+	if final {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// Writer encrypting everything written to it into sealed blocks of at most SEAL_SIZE bytes.
+// Blocks are numbered, and the last one is marked, so reordering and truncation are detected.
+type sealWriter struct {
+	writer io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint64
+	buff   []byte
+}
+
+func (sealer *sealWriter) Write(buff []byte) (n int, err error) {
+	for len(buff) > 0 {
+		space := min(len(buff), SEAL_SIZE-len(sealer.buff))
+		sealer.buff = append(sealer.buff, buff[:space]...)
+		buff = buff[space:]
+		n += space
+
+		if len(sealer.buff) == SEAL_SIZE {
+			if err = sealer.seal(false); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Seals the buffered bytes, if any, so they can be decrypted before the stream ends
+func (sealer *sealWriter) Flush() error {
+	if len(sealer.buff) == 0 {
+		return nil
+	}
+	return sealer.seal(false)
+}
+
+// Seals the last block
+func (sealer *sealWriter) Close() error {
+	return sealer.seal(true)
+}
+
+func (sealer *sealWriter) seal(final bool) (err error) {
+
+	sealed := sealer.aead.Seal(nil, sealNonce(sealer.aead, sealer.index), sealer.buff,
+		sealData(sealer.prefix, final))
+	sealer.index++
+	sealer.buff = sealer.buff[:0]
+
+	if err = writeBlockHeader(sealer.writer, BLOCK_ID_SEALED, uint64(len(sealed))); err != nil {
+		return
+	}
+
+	_, err = sealer.writer.Write(sealed)
+	return
+}
+
+// Reader decrypting sealed blocks, until the last one
+type openReader struct {
+	reader io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint64
+	buff   []byte
+	final  bool
+}
+
+func (opener *openReader) Read(buff []byte) (n int, err error) {
+	for len(opener.buff) == 0 {
+		if opener.final {
+			return 0, io.EOF
+		}
+
+		if err = opener.open(); err != nil {
+			// the stream ends before its last sealed block
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+
+	n = copy(buff, opener.buff)
+	opener.buff = opener.buff[n:]
+	return
+}
+
+// Reads until the last sealed block and fails if any data is left
+func (opener *openReader) end() (err error) {
+	for !opener.final {
+		if err = opener.open(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+
+	if len(opener.buff) != 0 {
+		err = ErrUnexpectedBlock
+	}
+	return
+}
+
+func (opener *openReader) open() (err error) {
+
+	length, err := readBlockHeader(opener.reader, BLOCK_ID_SEALED)
+	if err != nil {
+		if err == ErrUnexpectedBlock {
+			err = ErrInvalidSealed
+		}
+		return
+	}
+
+	if length < uint64(opener.aead.Overhead()) || length > uint64(SEAL_SIZE+opener.aead.Overhead()) {
+		return ErrInvalidSealed
+	}
+
+	sealed := make([]byte, length)
+	if _, err = io.ReadFull(opener.reader, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	nonce := sealNonce(opener.aead, opener.index)
+
+	// Only the last block is sealed as final
+	for _, final := range []bool{false, true} {
+		plain, open_err := opener.aead.Open(nil, nonce, sealed, sealData(opener.prefix, final))
+		if open_err == nil {
+			opener.buff = plain
+			opener.final = final
+			opener.index++
+			return
+		}
+	}
+	return ErrAuthentication
+}
+
+// Returns the additional data prefix of an encrypted stream: the header block if any and the encryption block
+func encryptionPrefix(header *Header, params *encryption) (prefix []byte, err error) {
+
+	var buff bytes.Buffer
+	if header != nil {
+		if err = header.encodeHeader(&buff); err != nil {
+			return
+		}
+	}
+
+	err = params.encodeEncryption(&buff)
+	prefix = buff.Bytes()
+	return
+}
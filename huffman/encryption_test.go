@@ -0,0 +1,175 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func init() {
+	// keeps the tests fast, the iteration count is stored in the stream
+	pbkdf2_iterations = 1000
+}
+
+func encryptionTestInput() []byte {
+	input := make([]byte, 6*SEAL_SIZE+100)
+	for i := range input {
+		input[i] = byte(rand.Intn(16))
+	}
+	return input
+}
+
+func encrypt(t *testing.T, input []byte, options Options) []byte {
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+	return compressed.Bytes()
+}
+
+func decrypt(compressed []byte, options DecodeOptions) ([]byte, error) {
+	var output bytes.Buffer
+	err := DecodeContext(context.Background(), bytes.NewReader(compressed), &output, options)
+	return output.Bytes(), err
+}
+
+func TestEncryption(t *testing.T) {
+
+	input := encryptionTestInput()
+	key := bytes.Repeat([]byte{0x42}, KEY_SIZE)
+
+	for _, level := range []int{MIN_LEVEL, MAX_LEVEL} {
+		for _, passphrase := range []string{"", "secret"} {
+			options := LevelOptions(level)
+			options.Header = &Header{Name: "file"}
+			decode_options := DecodeOptions{}
+
+			if passphrase != "" {
+				options.Passphrase = passphrase
+				decode_options.Passphrase = passphrase
+			} else {
+				options.Key = key
+				decode_options.Key = key
+			}
+
+			compressed := encrypt(t, input, options)
+
+			// the header is authenticated, but not encrypted
+			if !bytes.Contains(compressed, []byte("file")) {
+				t.Errorf("Expected the header to be stored in plain text")
+			}
+
+			output, err := decrypt(compressed, decode_options)
+			if err != nil || !bytes.Equal(output, input) {
+				t.Errorf("Level %d: got error %v", level, err)
+			}
+
+			info, err := ListWithOptions(bytes.NewReader(compressed), decode_options)
+			if err != nil || !info.Encrypted || info.Blocks[1].ID != BLOCK_ID_ENCRYPTION {
+				t.Errorf("Expected encrypted stream, got %+v and error %v", info, err)
+			}
+
+			if _, err = decrypt(compressed, DecodeOptions{}); err != ErrKeyRequired {
+				t.Errorf("Expected '%s', got %v", ErrKeyRequired, err)
+			}
+		}
+	}
+}
+
+func TestEncryptionFlush(t *testing.T) {
+
+	key := bytes.Repeat([]byte{0x42}, KEY_SIZE)
+	options := DefaultOptions()
+	options.Key = key
+
+	var compressed bytes.Buffer
+	writer, _ := NewWriterOptions(&compressed, options)
+	writer.Write([]byte("first part"))
+	writer.Flush()
+
+	// what was flushed can be decrypted before the stream ends
+	output, err := decrypt(compressed.Bytes(), DecodeOptions{Key: key})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || string(output) != "first part" {
+		t.Errorf("Got '%s' and error %v", output, err)
+	}
+
+	writer.Write([]byte(", second part"))
+	writer.Close()
+
+	// members are encrypted independently
+	second := encrypt(t, []byte(", third part"), options)
+
+	output, err = decrypt(append(compressed.Bytes(), second...), DecodeOptions{Key: key})
+	if err != nil || string(output) != "first part, second part, third part" {
+		t.Errorf("Got '%s' and error %v", output, err)
+	}
+}
+
+func TestEncryptionTampered(t *testing.T) {
+
+	input := encryptionTestInput()
+	key := bytes.Repeat([]byte{0x42}, KEY_SIZE)
+
+	options := DefaultOptions()
+	options.Header = &Header{Name: "file"}
+	options.Key = key
+	decode_options := DecodeOptions{Key: key}
+
+	compressed := encrypt(t, input, options)
+	info, _ := List(bytes.NewReader(compressed))
+	sealed_offset := int(info.Blocks[1].Offset + info.Blocks[1].Length)
+
+	flip := func(offset int) []byte {
+		tampered := append([]byte{}, compressed...)
+		tampered[offset] ^= 0x1
+		return tampered
+	}
+
+	// the sealed blocks, in order
+	var sealed [][]byte
+	for offset := sealed_offset; offset < len(compressed); {
+		length := 9 + int(compressed[offset+1]) + int(compressed[offset+2])<<8 + int(compressed[offset+3])<<16
+		sealed = append(sealed, compressed[offset:offset+length])
+		offset += length
+	}
+
+	prefix := compressed[:sealed_offset:sealed_offset]
+	join := func(blocks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{prefix}, blocks...), nil)
+	}
+
+	last := len(sealed) - 1
+	if last < 2 {
+		t.Fatalf("Expected at least 3 sealed blocks, got %d", len(sealed))
+	}
+
+	tests := []struct {
+		input    []byte
+		expected error
+	}{
+		{flip(12), ErrAuthentication},
+		{flip(sealed_offset + 20), ErrAuthentication},
+		{flip(len(compressed) - 1), ErrAuthentication},
+		{join(append([][]byte{sealed[1], sealed[0]}, sealed[2:]...)...), ErrAuthentication},
+		{join(sealed[:last]...), io.ErrUnexpectedEOF},
+		{join(append(append([][]byte{}, sealed[:last-1]...), sealed[last])...), ErrAuthentication},
+		{append(compressed[:len(compressed):len(compressed)], sealed[last]...), ErrNotEncrypted},
+		{encrypt(t, input, DefaultOptions()), ErrNotEncrypted}}
+
+	for i, test := range tests {
+		if _, err := decrypt(test.input, decode_options); !errors.Is(err, test.expected) {
+			t.Errorf("Test %d: expected '%s', got %v", i, test.expected, err)
+		}
+	}
+
+	if _, err := decrypt(compressed, DecodeOptions{Key: bytes.Repeat([]byte{0x43}, KEY_SIZE)}); err != ErrAuthentication {
+		t.Errorf("Expected '%s', got %v", ErrAuthentication, err)
+	}
+
+	if _, err := decrypt(compressed, DecodeOptions{Passphrase: "secret"}); err != ErrKeyRequired {
+		t.Errorf("Expected '%s', got %v", ErrKeyRequired, err)
+	}
+}
@@ -0,0 +1,68 @@
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors describing why a stream could not be decoded.
+// Decode wraps them in a *FormatError, use errors.Is to test for them.
+// Truncated streams are reported with io.ErrUnexpectedEOF.
+var (
+	ErrNotDense        = errors.New("Not a dense stream")
+	ErrUnexpectedBlock = errors.New("Unexpected block ID")
+	ErrInvalidShape    = errors.New("Invalid tree shape")
+	ErrInvalidLeaves   = errors.New("Invalid tree leaves")
+	ErrInvalidBody     = errors.New("Invalid data block")
+	ErrInvalidTrailer  = errors.New("Invalid trailer")
+	ErrUnknownChecksum = errors.New("Unknown checksum type")
+)
+
+var (
+	// The decompressed data does not match the size or checksum in the trailer
+	ErrChecksum = errors.New("Checksum mismatch")
+
+	// The decompressed data would exceed DecodeOptions.MaxOutput
+	ErrOutputLimit = errors.New("Output exceeds limit")
+)
+
+// Error in the block of a stream starting at Offset
+type FormatError struct {
+	BlockID byte
+	Offset  int64
+	Err     error
+}
+
+func (err *FormatError) Error() string {
+	switch err.Err {
+	case ErrNotDense:
+		return err.Err.Error()
+	case io.ErrUnexpectedEOF:
+		return fmt.Sprintf("Truncated stream in %s block at offset %d", BlockName(err.BlockID), err.Offset)
+	}
+	return fmt.Sprintf("%s in %s block at offset %d", err.Err, BlockName(err.BlockID), err.Offset)
+}
+
+func (err *FormatError) Unwrap() error {
+	return err.Err
+}
+
+// Wraps errors caused by invalid or truncated data in a *FormatError
+func newFormatError(err error, block_id byte, offset int64) error {
+	format_errors := []error{ErrNotDense, ErrUnexpectedBlock, ErrInvalidShape, ErrInvalidLeaves,
+		ErrInvalidBody, ErrInvalidTrailer, ErrUnknownChecksum, ErrInvalidTableRef, ErrInvalidDictionaryRef,
+		ErrInvalidContext, ErrInvalidSync, ErrInvalidHeader,
+		ErrInvalidEncryption, ErrInvalidSealed,
+		ErrInvalidParity, ErrUncorrectable, ErrInvalidResync, io.ErrUnexpectedEOF}
+
+	for _, format_error := range format_errors {
+		if err == format_error {
+			return &FormatError{
+				BlockID: block_id,
+				Offset:  offset,
+				Err:     err}
+		}
+	}
+	return err
+}
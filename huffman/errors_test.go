@@ -0,0 +1,79 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFormatError(t *testing.T) {
+
+	var compressed bytes.Buffer
+	if err := Encode(bytes.NewBufferString("this is some content"), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, _ := List(bytes.NewReader(compressed.Bytes()))
+	leaves_offset := info.Blocks[1].Offset
+	trailer_offset := info.Blocks[3].Offset
+
+	corrupt := func(offset int64, value byte) []byte {
+		corrupted := append([]byte{}, compressed.Bytes()...)
+		corrupted[offset] = value
+		return corrupted
+	}
+
+	tests := []struct {
+		input    []byte
+		expected error
+		block_id byte
+		offset   int64
+	}{
+		{[]byte{}, io.ErrUnexpectedEOF, BLOCK_ID_SHAPE, 0},
+		{[]byte("PK\x03\x04"), ErrNotDense, 'P', 0},
+		{compressed.Bytes()[:leaves_offset+3], io.ErrUnexpectedEOF, BLOCK_ID_LEAVES, leaves_offset},
+		{corrupt(leaves_offset+1, 0xFF), ErrInvalidLeaves, BLOCK_ID_LEAVES, leaves_offset},
+		{corrupt(trailer_offset, 0x7F), ErrUnexpectedBlock, 0x7F, trailer_offset},
+		{corrupt(trailer_offset+9, 0x7F), ErrUnknownChecksum, BLOCK_ID_TRAILER, trailer_offset}}
+
+	for i, test := range tests {
+		var output bytes.Buffer
+		err := Decode(bytes.NewReader(test.input), &output)
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Test %d: expected '%s', got %v", i, test.expected, err)
+		}
+
+		var format_error *FormatError
+		if !errors.As(err, &format_error) {
+			t.Errorf("Test %d: expected *FormatError, got %T", i, err)
+			continue
+		}
+
+		if format_error.BlockID != test.block_id || format_error.Offset != test.offset {
+			t.Errorf("Test %d: got %+v", i, format_error)
+		}
+	}
+}
+
+func TestFormatErrorNotWrapped(t *testing.T) {
+
+	if err := newFormatError(io.EOF, BLOCK_ID_DATA, 0); err != io.EOF {
+		t.Errorf("Expected '%s', got %v", io.EOF, err)
+	}
+
+	var compressed bytes.Buffer
+	if err := Encode(bytes.NewBufferString("this is some content"), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	// a checksum mismatch is not a format error
+	corrupted := compressed.Bytes()
+	corrupted[len(corrupted)-1] ^= 0x1
+
+	var output bytes.Buffer
+	if err := Decode(bytes.NewReader(corrupted), &output); err != ErrChecksum {
+		t.Errorf("Expected '%s', got %v", ErrChecksum, err)
+	}
+}
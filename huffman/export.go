@@ -0,0 +1,174 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Leaf of a tree as exported by Leaves
+type TreeLeaf[S Symbol] struct {
+	Symbol S      `json:"symbol"`
+	Weight int64  `json:"weight"`
+	Code   string `json:"code"`
+	Depth  int    `json:"depth"`
+}
+
+// Builds the tree Encode would use for the input
+func BuildTree(reader io.Reader) (tree *HuffmanTree, err error) {
+	return generateTree(reader)
+}
+
+// Returns the trees of every block of a stream, in stream order.
+// Trees are read from the stream, so their weights are the number of symbols each block codes with them.
+func ReadTrees(reader io.Reader, options DecodeOptions) (trees []*HuffmanTree, err error) {
+
+	decoder := newDecoder(context.Background(), reader, io.Discard, options, nil)
+	decoder.trees = &trees
+
+	err = decoder.decode()
+	return
+}
+
+// Returns the weight of the tree, the total weight of its leaves
+func (node *SymbolTree[S]) Weight() int64 {
+	return node.weight
+}
+
+// Returns the leaves from left to right, with their code as a string of zeros and ones
+func (node *SymbolTree[S]) Leaves() (leaves []TreeLeaf[S]) {
+
+	var visit func(*SymbolTree[S], string)
+
+	visit = func(node *SymbolTree[S], code string) {
+		if node.left != nil {
+			visit(node.left, code+"0")
+			visit(node.right, code+"1")
+			return
+		}
+
+		leaves = append(leaves, TreeLeaf[S]{
+			Symbol: node.data,
+			Weight: node.weight,
+			Code:   code,
+			Depth:  len(code)})
+	}
+
+	visit(node, "")
+	return
+}
+
+// Writes the leaves as a JSON array
+func (node *SymbolTree[S]) WriteJSON(writer io.Writer) (err error) {
+	return json.NewEncoder(writer).Encode(node.Leaves())
+}
+
+// Writes the tree as a Graphviz graph with the given name.
+// Internal nodes show their weight, leaves their symbol and weight. Edges are labelled with their bit.
+func (node *SymbolTree[S]) WriteDOT(writer io.Writer, name string) (err error) {
+
+	var dot_buff bytes.Buffer
+	fmt.Fprintf(&dot_buff, "digraph %s {\n", strconv.Quote(name))
+
+	var visit func(*SymbolTree[S], string)
+
+	visit = func(node *SymbolTree[S], code string) {
+		id := strconv.Quote("n" + code)
+
+		if node.left == nil {
+			label := fmt.Sprintf("%s\n%d", symbolLabel(node.data), node.weight)
+			fmt.Fprintf(&dot_buff, "\t%s [shape=box, label=%s];\n", id, strconv.Quote(label))
+			return
+		}
+
+		fmt.Fprintf(&dot_buff, "\t%s [label=\"%d\"];\n", id, node.weight)
+		fmt.Fprintf(&dot_buff, "\t%s -> %s [label=\"0\"];\n", id, strconv.Quote("n"+code+"0"))
+		fmt.Fprintf(&dot_buff, "\t%s -> %s [label=\"1\"];\n", id, strconv.Quote("n"+code+"1"))
+
+		visit(node.left, code+"0")
+		visit(node.right, code+"1")
+	}
+
+	visit(node, "")
+	dot_buff.WriteString("}\n")
+
+	_, err = dot_buff.WriteTo(writer)
+	return
+}
+
+// Returns a readable representation of a symbol: bytes and strings quoted, 16-bit symbols as number
+func symbolLabel[S Symbol](symbol S) string {
+	switch value := any(symbol).(type) {
+	case byte:
+		return strconv.Quote(string([]byte{value}))
+	case uint16:
+		return strconv.Itoa(int(value))
+	case string:
+		return strconv.Quote(value)
+	}
+	return ""
+}
+
+// Returns a copy of the tree weighted by the symbol counts.
+// Only the leftmost leaf of a symbol is weighted, as a tree only contains a symbol twice for a dummy leaf.
+func (node *SymbolTree[S]) weighted(counts map[S]int64) (tree *SymbolTree[S]) {
+
+	weighted := make(map[S]bool)
+
+	var visit func(*SymbolTree[S]) *SymbolTree[S]
+
+	visit = func(node *SymbolTree[S]) *SymbolTree[S] {
+		if node.left == nil {
+			leaf := &SymbolTree[S]{
+				data: node.data}
+			if !weighted[node.data] {
+				leaf.weight = counts[node.data]
+				weighted[node.data] = true
+			}
+			return leaf
+		}
+
+		copy := &SymbolTree[S]{
+			left:  visit(node.left),
+			right: visit(node.right)}
+		copy.weight = copy.left.weight + copy.right.weight
+		return copy
+	}
+
+	return visit(node)
+}
+
+// Returns copies of the trees of a data block, weighted by the number of bytes decoded with them
+func weightedTrees(trees bodyDecoder, counts *contextCounts) (weighted []*HuffmanTree) {
+
+	// Sums the counts of the contexts for which use_context returns true
+	sum_counts := func(use_context func(context int) bool) map[byte]int64 {
+		tree_counts := make(map[byte]int64)
+		for context := range counts {
+			if !use_context(context) {
+				continue
+			}
+			for b, count := range counts[context] {
+				if count > 0 {
+					tree_counts[byte(b)] += count
+				}
+			}
+		}
+		return tree_counts
+	}
+
+	switch trees := trees.(type) {
+	case *HuffmanTree:
+		tree_counts := sum_counts(func(int) bool { return true })
+		weighted = append(weighted, trees.weighted(tree_counts))
+	case *contextTrees:
+		for i, tree := range trees.trees {
+			tree_counts := sum_counts(func(context int) bool { return int(trees.indexes[context]) == i })
+			weighted = append(weighted, tree.weighted(tree_counts))
+		}
+	}
+	return
+}
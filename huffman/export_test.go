@@ -0,0 +1,132 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTreeLeaves(t *testing.T) {
+
+	tree := &HuffmanTree{
+		weight: 6,
+		left: &HuffmanTree{
+			weight: 2,
+			left: &HuffmanTree{
+				data:   'c',
+				weight: 1},
+			right: &HuffmanTree{
+				data:   'b',
+				weight: 1}},
+		right: &HuffmanTree{
+			data:   'a',
+			weight: 4}}
+
+	expected_leaves := []TreeLeaf[byte]{
+		{Symbol: 'c', Weight: 1, Code: "00", Depth: 2},
+		{Symbol: 'b', Weight: 1, Code: "01", Depth: 2},
+		{Symbol: 'a', Weight: 4, Code: "1", Depth: 1}}
+
+	if !reflect.DeepEqual(tree.Leaves(), expected_leaves) {
+		t.Errorf("Expected %+v, got %+v", expected_leaves, tree.Leaves())
+	}
+
+	var buff bytes.Buffer
+	if err := tree.WriteJSON(&buff); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	var leaves []TreeLeaf[byte]
+	if err := json.Unmarshal(buff.Bytes(), &leaves); err != nil || !reflect.DeepEqual(leaves, expected_leaves) {
+		t.Errorf("Expected %+v, got %+v and error %v", expected_leaves, leaves, err)
+	}
+
+	buff.Reset()
+	if err := tree.WriteDOT(&buff, "tree"); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	expected_lines := []string{
+		`digraph "tree" {`,
+		`	"n" [label="6"];`,
+		`	"n" -> "n0" [label="0"];`,
+		`	"n1" [shape=box, label="\"a\"\n4"];`}
+
+	for _, line := range expected_lines {
+		if !strings.Contains(buff.String(), line+"\n") {
+			t.Errorf("Expected line %s in\n%s", line, buff.String())
+		}
+	}
+}
+
+func TestReadTrees(t *testing.T) {
+
+	input := []byte("abracadabra")
+
+	options := DefaultOptions()
+	options.BlockSize = 5
+
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	trees, err := ReadTrees(&compressed, DecodeOptions{})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	// blocks "abrac", "adabr" and "a"
+	expected_weights := []map[byte]int64{
+		{'a': 2, 'b': 1, 'r': 1, 'c': 1},
+		{'a': 2, 'b': 1, 'r': 1, 'd': 1},
+		{'a': 1}}
+
+	if len(trees) != len(expected_weights) {
+		t.Fatalf("Expected %d trees, got %d", len(expected_weights), len(trees))
+	}
+
+	for i, tree := range trees {
+		weights := make(map[byte]int64)
+		for _, leaf := range tree.Leaves() {
+			if leaf.Weight > 0 {
+				weights[leaf.Symbol] = leaf.Weight
+			}
+		}
+
+		if !reflect.DeepEqual(weights, expected_weights[i]) {
+			t.Errorf("Tree %d: expected weights %v, got %v", i, expected_weights[i], weights)
+		}
+
+		if tree.Weight() != 5 && i < 2 {
+			t.Errorf("Tree %d: expected weight 5, got %d", i, tree.Weight())
+		}
+	}
+}
+
+func TestReadTreesOrder1(t *testing.T) {
+
+	input := []byte(strings.Repeat("abcabcabcaaaa", 1000) + "xyz")
+
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, LevelOptions(9)); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	trees, err := ReadTrees(&compressed, DecodeOptions{})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	total_weight := int64(0)
+	for _, tree := range trees {
+		total_weight += tree.Weight()
+	}
+
+	if len(trees) < 2 || total_weight != int64(len(input)) {
+		t.Errorf("Expected several trees of total weight %d, got %d trees of weight %d",
+			len(input), len(trees), total_weight)
+	}
+}
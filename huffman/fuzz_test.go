@@ -0,0 +1,169 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// Limits the output of fuzzed streams, so a valid stream claiming a huge body cannot stall the fuzzer
+const FUZZ_MAX_OUTPUT = 1 << 20
+
+// Passphrase of the encrypted seeds
+const FUZZ_PASSPHRASE = "fuzz"
+
+var fuzz_inputs = [][]byte{
+	[]byte{},
+	[]byte{0x0},
+	[]byte("dense"),
+	bytes.Repeat([]byte("ab"), 100)}
+
+// Table and dictionary of the seeds using them, trained on the seed inputs
+var fuzz_table, _ = TrainTable(bytes.NewReader(bytes.Join(fuzz_inputs, nil)))
+var fuzz_dictionary, _ = TrainDictionary(bytes.NewReader(bytes.Join(fuzz_inputs, nil)))
+
+func fuzzSeedStreams() (seeds [][]byte) {
+	var seed_options []Options
+	for _, level := range []int{DEFAULT_LEVEL, MAX_LEVEL} {
+		seed_options = append(seed_options, LevelOptions(level))
+	}
+
+	options := DefaultOptions()
+	options.Recoverable = true
+	seed_options = append(seed_options, options)
+
+	options = DefaultOptions()
+	options.Parity = 5
+	seed_options = append(seed_options, options)
+
+	options = DefaultOptions()
+	options.Passphrase = FUZZ_PASSPHRASE
+	seed_options = append(seed_options, options)
+
+	options = DefaultOptions()
+	options.Table = fuzz_table
+	seed_options = append(seed_options, options)
+
+	options = DefaultOptions()
+	options.Dictionary = fuzz_dictionary
+	seed_options = append(seed_options, options)
+
+	for _, input := range fuzz_inputs {
+		for _, options := range seed_options {
+			var buff bytes.Buffer
+			EncodeWithOptions(bytes.NewReader(input), &buff, options)
+			seeds = append(seeds, buff.Bytes())
+		}
+	}
+	return
+}
+
+func FuzzDecode(f *testing.F) {
+
+	for _, seed := range fuzzSeedStreams() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var output bytes.Buffer
+		options := DecodeOptions{
+			MaxOutput:  FUZZ_MAX_OUTPUT,
+			Tables:     []*Table{fuzz_table},
+			Dictionary: fuzz_dictionary,
+			Recover:    func(offset, size int64) {}}
+
+		DecodeContext(context.Background(), bytes.NewReader(input), &output, options)
+
+		// encrypted streams are only decoded with their passphrase
+		output.Reset()
+		options.Passphrase = FUZZ_PASSPHRASE
+		DecodeContext(context.Background(), bytes.NewReader(input), &output, options)
+	})
+}
+
+func FuzzDecodeTreeShape(f *testing.F) {
+
+	for _, seed := range fuzzSeedStreams() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		tree, err := decodeTreeShape(bytes.NewReader(input))
+		if err != nil {
+			return
+		}
+
+		// a decoded shape should encode to the same bytes
+		var output bytes.Buffer
+		if err = tree.encodeTreeShape(&output); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if !bytes.HasPrefix(input, output.Bytes()) {
+			t.Errorf("Shape %v encoded as %v", input, output.Bytes())
+		}
+	})
+}
+
+func FuzzDecodeTreeLeaves(f *testing.F) {
+
+	for _, seed := range fuzzSeedStreams() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		reader := bytes.NewReader(input)
+
+		tree, err := decodeTreeShape(reader)
+		if err != nil {
+			return
+		}
+
+		tree.decodeTreeLeaves(reader)
+	})
+}
+
+func FuzzReadTable(f *testing.F) {
+
+	table, _ := TrainTable(bytes.NewBufferString("dense"))
+	var table_buff bytes.Buffer
+	table.WriteTo(&table_buff)
+	f.Add(table_buff.Bytes())
+
+	for _, seed := range fuzzSeedStreams() {
+		f.Add(append([]byte(TABLE_MAGIC), seed...))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		ReadTable(bytes.NewReader(input))
+	})
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+
+	f.Add([]byte{}, int64(0))
+	f.Add([]byte("dense"), int64(2))
+	f.Add(bytes.Repeat([]byte("ab"), 100), int64(7))
+
+	f.Fuzz(func(t *testing.T, input []byte, block_size int64) {
+		if block_size < 0 {
+			block_size = -block_size
+		}
+
+		options := DefaultOptions()
+		options.BlockSize = block_size % 1024
+
+		var compressed, output bytes.Buffer
+		if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		if err := Decode(&compressed, &output); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		if !bytes.Equal(input, output.Bytes()) {
+			t.Errorf("Expected %v, got %v", input, output.Bytes())
+		}
+	})
+}
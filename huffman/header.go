@@ -0,0 +1,184 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"time"
+)
+
+// Flags of the header block, one per field present
+const (
+	HEADER_NAME     = 1 << 0
+	HEADER_MOD_TIME = 1 << 1
+	HEADER_MODE     = 1 << 2
+	HEADER_SIZE     = 1 << 3
+)
+
+// Maximum length of the header block body
+const MAX_HEADER_LEN = 1 << 16
+
+var ErrInvalidHeader = errors.New("Invalid header block")
+
+// Optional description of the compressed file, stored in a header block at the start of a stream
+type Header struct {
+	// Base name of the file, empty if unknown
+	Name string
+
+	// Modification time of the file, zero if unknown
+	ModTime time.Time
+
+	// Mode and permissions of the file, zero if unknown
+	Mode fs.FileMode
+
+	// Uncompressed size of the stream
+	HasSize bool
+	Size    int64
+}
+
+func (header *Header) encodeHeader(writer io.Writer) (err error) {
+
+	flags := byte(0)
+	body := []byte{0}
+
+	if header.Name != "" {
+		flags |= HEADER_NAME
+		body = binary.AppendUvarint(body, uint64(len(header.Name)))
+		body = append(body, header.Name...)
+	}
+
+	if !header.ModTime.IsZero() {
+		flags |= HEADER_MOD_TIME
+		body = binary.AppendVarint(body, header.ModTime.Unix())
+		body = binary.AppendUvarint(body, uint64(header.ModTime.Nanosecond()))
+	}
+
+	if header.Mode != 0 {
+		flags |= HEADER_MODE
+		body = binary.AppendUvarint(body, uint64(header.Mode))
+	}
+
+	if header.HasSize {
+		flags |= HEADER_SIZE
+		body = binary.AppendUvarint(body, uint64(header.Size))
+	}
+	body[0] = flags
+
+	if len(body) > MAX_HEADER_LEN {
+		return ErrInvalidHeader
+	}
+
+	if err = writeBlockHeader(writer, BLOCK_ID_HEADER, uint64(len(body))); err != nil {
+		return
+	}
+
+	_, err = writer.Write(body)
+	return
+}
+
+func decodeHeader(reader io.Reader) (header *Header, err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_HEADER)
+	if err != nil {
+		return
+	}
+
+	if length == 0 || length > MAX_HEADER_LEN {
+		err = ErrInvalidHeader
+		return
+	}
+
+	body := make([]byte, length)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	flags := body[0]
+	if flags&^(HEADER_NAME|HEADER_MOD_TIME|HEADER_MODE|HEADER_SIZE) != 0 {
+		err = ErrInvalidHeader
+		return
+	}
+
+	buff := bytes.NewReader(body[1:])
+	header = &Header{}
+
+	// Any value ending early makes the header invalid
+	defer func() {
+		if err != nil {
+			header = nil
+			err = ErrInvalidHeader
+		}
+	}()
+
+	if flags&HEADER_NAME != 0 {
+		var name_len uint64
+		if name_len, err = binary.ReadUvarint(buff); err != nil {
+			return
+		}
+
+		if name_len > uint64(buff.Len()) {
+			err = ErrInvalidHeader
+			return
+		}
+
+		name := make([]byte, name_len)
+		buff.Read(name)
+		header.Name = string(name)
+	}
+
+	if flags&HEADER_MOD_TIME != 0 {
+		var seconds int64
+		var nanoseconds uint64
+		if seconds, err = binary.ReadVarint(buff); err != nil {
+			return
+		}
+
+		if nanoseconds, err = binary.ReadUvarint(buff); err != nil {
+			return
+		}
+
+		if nanoseconds >= uint64(time.Second) {
+			err = ErrInvalidHeader
+			return
+		}
+		header.ModTime = time.Unix(seconds, int64(nanoseconds))
+	}
+
+	if flags&HEADER_MODE != 0 {
+		var mode uint64
+		if mode, err = binary.ReadUvarint(buff); err != nil {
+			return
+		}
+
+		if mode > math.MaxUint32 {
+			err = ErrInvalidHeader
+			return
+		}
+		header.Mode = fs.FileMode(mode)
+	}
+
+	if flags&HEADER_SIZE != 0 {
+		var size uint64
+		if size, err = binary.ReadUvarint(buff); err != nil {
+			return
+		}
+
+		if size > math.MaxInt64 {
+			err = ErrInvalidHeader
+			return
+		}
+		header.HasSize = true
+		header.Size = int64(size)
+	}
+
+	if buff.Len() != 0 {
+		err = ErrInvalidHeader
+	}
+	return
+}
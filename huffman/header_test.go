@@ -0,0 +1,126 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+
+	headers := []Header{
+		Header{},
+		Header{Name: "file.txt"},
+		Header{
+			Name:    "naïve.txt",
+			ModTime: time.Date(1969, 7, 20, 20, 17, 40, 123, time.UTC),
+			Mode:    0640 | fs.ModeSetuid,
+			HasSize: true,
+			Size:    11}}
+
+	for _, header := range headers {
+		options := DefaultOptions()
+		options.Header = &header
+
+		var compressed bytes.Buffer
+		if err := EncodeWithOptions(bytes.NewBufferString("hello world"), &compressed, options); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		info, err := List(bytes.NewReader(compressed.Bytes()))
+		if err != nil || info.Blocks[0].ID != BLOCK_ID_HEADER {
+			t.Fatalf("Expected header block, got %+v and error %v", info, err)
+		}
+
+		reader, err := NewReader(&compressed)
+		if err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		if reader.Name != header.Name || !reader.ModTime.Equal(header.ModTime) || reader.Mode != header.Mode ||
+			reader.HasSize != header.HasSize || reader.Size != header.Size {
+			t.Errorf("Expected %+v, got %+v", header, reader.Header)
+		}
+
+		if *info.Header != reader.Header {
+			t.Errorf("Expected %+v, got %+v", reader.Header, *info.Header)
+		}
+
+		output, err := io.ReadAll(reader)
+		if err != nil || string(output) != "hello world" {
+			t.Errorf("Got '%s' and error %v", output, err)
+		}
+		reader.Close()
+	}
+}
+
+func TestReaderWithoutHeader(t *testing.T) {
+
+	var compressed bytes.Buffer
+	Encode(bytes.NewBufferString("hello world"), &compressed)
+
+	reader, err := NewReader(&compressed)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if reader.Header != (Header{}) {
+		t.Errorf("Expected empty header, got %+v", reader.Header)
+	}
+
+	// closing stops decoding before the end of the stream
+	reader.Close()
+
+	if _, err = reader.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Errorf("Expected '%s', got %v", io.ErrClosedPipe, err)
+	}
+
+	if _, err = NewReader(bytes.NewBufferString("PK\x03\x04")); !errors.Is(err, ErrNotDense) {
+		t.Errorf("Expected '%s', got %v", ErrNotDense, err)
+	}
+}
+
+func TestDecodeHeaderInvalid(t *testing.T) {
+
+	header := Header{
+		Name:    "file.txt",
+		HasSize: true}
+
+	var block bytes.Buffer
+	header.encodeHeader(&block)
+
+	corrupt := func(offset int, value byte) []byte {
+		corrupted := append([]byte{}, block.Bytes()...)
+		corrupted[offset] = value
+		return corrupted
+	}
+
+	inputs := [][]byte{
+		corrupt(9, 0xFF),
+		corrupt(10, 0x7F),
+		corrupt(1, byte(block.Len()-9-1)),
+		append(corrupt(1, byte(block.Len()-9+1)), 0x0)}
+
+	for i, input := range inputs {
+		if _, err := decodeHeader(bytes.NewReader(input)); err != ErrInvalidHeader {
+			t.Errorf("Input %d: expected '%s', got %v", i, ErrInvalidHeader, err)
+		}
+	}
+
+	// a header block after the first block is rejected
+	var compressed bytes.Buffer
+	Encode(bytes.NewBufferString("content"), &compressed)
+	info, _ := List(bytes.NewReader(compressed.Bytes()))
+	trailer_offset := info.Blocks[3].Offset
+
+	stream := append(compressed.Bytes()[:trailer_offset:trailer_offset], block.Bytes()...)
+	err := Decode(bytes.NewReader(stream), io.Discard)
+
+	var format_err *FormatError
+	if !errors.As(err, &format_err) || format_err.Err != ErrUnexpectedBlock || format_err.Offset != trailer_offset {
+		t.Errorf("Expected '%s' at offset %d, got %v", ErrUnexpectedBlock, trailer_offset, err)
+	}
+}
@@ -1,94 +1,122 @@
 package huffman
 
 import (
+	"bufio"
 	"bytes"
+	"container/heap"
+	"context"
 	"dense/bits"
 	"encoding/binary"
-	"errors"
 	"io"
-	"sort"
 )
 
 const (
-	BLOCK_ID_SHAPE  = 0
-	BLOCK_ID_LEAVES = 1
-	BLOCK_ID_DATA   = 2
+	BLOCK_ID_SHAPE      = 0
+	BLOCK_ID_LEAVES     = 1
+	BLOCK_ID_DATA       = 2
+	BLOCK_ID_TRAILER    = 3
+	BLOCK_ID_TABLE      = 4
+	BLOCK_ID_DICTIONARY = 5
+	BLOCK_ID_CONTEXT    = 6
+	BLOCK_ID_SYNC       = 7
+	BLOCK_ID_HEADER     = 8
+	BLOCK_ID_ENCRYPTION = 9
+	BLOCK_ID_SEALED     = 10
+	BLOCK_ID_PARITY     = 11
+	BLOCK_ID_RESYNC     = 12
 )
 
-func Encode(reader io.Reader, writer io.Writer) (err error) {
+// Maximum number of distinct symbols in a tree over bytes
+const MAX_LEAVES = 256
 
-	var freq_tree_reader, encode_reader bytes.Buffer
+// Maximum length of a code, which must fit in a bits.Slice of 64 bits
+// together with the up to 7 bits bits.Writer holds back
+const MAX_CODE_LEN = 64 - 7
 
-	multi_writer := io.MultiWriter(&freq_tree_reader, &encode_reader)
-	io.Copy(multi_writer, reader)
+// Maximum length of the shape block body: one bit per node of a full tree, padded
+const MAX_SHAPE_LEN = (2*MAX_LEAVES - 1 + 7) / 8
 
-	tree, err := generateTree(&freq_tree_reader)
+// Maximum length of the data block body, which keeps its length in bits within 64 bits
+const MAX_BODY_LEN = 1 << 60
 
-	if err != nil {
-		return
-	}
+// Compresses with default options
+func Encode(reader io.Reader, writer io.Writer) (err error) {
+	return EncodeWithOptions(reader, writer, DefaultOptions())
+}
 
-	if err = tree.encodeTreeShape(writer); err != nil {
-		return
-	}
+// Decompresses a stream
+func Decode(reader io.Reader, writer io.Writer) (err error) {
+	return DecodeContext(context.Background(), reader, writer, DecodeOptions{})
+}
 
-	if err = tree.encodeTreeLeaves(writer); err != nil {
-		return
+// Returns the number of bits encoding the counted bytes takes.
+// Ok is false if a counted byte has no code.
+func bodyBits(counts map[byte]int64, encoding_table map[byte]bits.Slice) (body_bits int64, ok bool) {
+	for key, count := range counts {
+		slice, found := encoding_table[key]
+		if !found && count > 0 {
+			return
+		}
+		body_bits += count * int64(slice.Len())
 	}
-
-	table := tree.getEncodingTable()
-	err = tree.encodeBody(&encode_reader, writer, table)
+	ok = true
 	return
 }
 
-func Decode(reader io.Reader, writer io.Writer) (err error) {
+// Huffman tree over symbols of type S, only leaves have a symbol
+type SymbolTree[S Symbol] struct {
+	data   S
+	weight int64
+	left   *SymbolTree[S]
+	right  *SymbolTree[S]
+}
 
-	tree, err := decodeTreeShape(reader)
-	if err != nil {
-		return err
-	}
+// Tree over bytes, as used by the stream format
+type HuffmanTree = SymbolTree[byte]
 
-	err = tree.decodeTreeLeaves(reader)
+func generateTree(reader io.Reader) (tree *HuffmanTree, err error) {
+
+	table, err := countBytes(reader)
 	if err != nil {
-		return err
+		return
 	}
 
-	err = tree.decodeBody(reader, writer)
+	tree = generateTreeFromCounts(table)
 	return
 }
 
-type HuffmanTree struct {
-	data   byte
-	weight int64
-	left   *HuffmanTree
-	right  *HuffmanTree
-}
-
-func generateTree(reader io.Reader) (tree *HuffmanTree, err error) {
+// Counts the occurrences of every byte value
+func countBytes(reader io.Reader) (table map[byte]int64, err error) {
 
 	buff := make([]byte, 4096)
-	table := make(map[byte]int64, 256)
+	table = make(map[byte]int64, 256)
 
 	for {
 		var read_bytes int
 		read_bytes, err = reader.Read(buff)
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-				break
-			}
-			return
-		}
 
+		// a reader may return data along with an error
 		for _, b := range buff[:read_bytes] {
 			if _, ok := table[b]; !ok {
 				table[b] = 0
 			}
 			table[b]++
 		}
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			return
+		}
 	}
+	return
+}
+
+func generateTreeFromCounts[S Symbol](table map[S]int64) (tree *SymbolTree[S]) {
 
-	slice := make([]HuffmanTree, 0)
+	nodes := make(treeHeap[S], 0, len(table))
 
 	for key, value := range table {
 
@@ -96,81 +124,93 @@ func generateTree(reader io.Reader) (tree *HuffmanTree, err error) {
 			continue
 		}
 
-		slice = append(slice, HuffmanTree{
+		nodes = append(nodes, &SymbolTree[S]{
 			data:   key,
 			weight: value,
 			left:   nil,
 			right:  nil})
 	}
 
-	if len(slice) == 0 {
+	if len(nodes) == 0 {
 		// No input data. Put a useless root in the tree
-		tree = &HuffmanTree{}
+		tree = &SymbolTree[S]{}
 		return
 	}
-	if len(slice) == 1 {
-		// Only one byte value in input. Put dummy node as sibling.
-		tree = &HuffmanTree{
-			left:   &slice[0],
-			right:  &HuffmanTree{},
-			weight: slice[0].weight}
+	if len(nodes) == 1 {
+		// Only one symbol in input. Put dummy node as sibling.
+		tree = &SymbolTree[S]{
+			left:   nodes[0],
+			right:  &SymbolTree[S]{},
+			weight: nodes[0].weight}
 		return
 	}
 
-	for len(slice) > 1 {
-		sort.Slice(slice, func(i, j int) bool { return slice[i].weight > slice[j].weight })
+	// The lightest node becomes the left child, the next lightest the right child
+	heap.Init(&nodes)
+	for nodes.Len() > 1 {
+		left := heap.Pop(&nodes).(*SymbolTree[S])
+		right := heap.Pop(&nodes).(*SymbolTree[S])
 
-		left := new(HuffmanTree)
-		right := new(HuffmanTree)
-
-		*left = slice[len(slice)-1]
-		*right = slice[len(slice)-2]
-
-		slice = slice[:len(slice)-1]
-		slice[len(slice)-1] = HuffmanTree{
-			data:   byte(0),
+		heap.Push(&nodes, &SymbolTree[S]{
 			weight: left.weight + right.weight,
 			left:   left,
-			right:  right}
-
+			right:  right})
 	}
-	tree = new(HuffmanTree)
 
-	*tree = slice[0]
+	tree = nodes[0]
 	return
 }
 
+// Min-heap of trees by weight
+type treeHeap[S Symbol] []*SymbolTree[S]
+
+func (nodes treeHeap[S]) Len() int           { return len(nodes) }
+func (nodes treeHeap[S]) Less(i, j int) bool { return nodes[i].weight < nodes[j].weight }
+func (nodes treeHeap[S]) Swap(i, j int)      { nodes[i], nodes[j] = nodes[j], nodes[i] }
+func (nodes *treeHeap[S]) Push(node any)     { *nodes = append(*nodes, node.(*SymbolTree[S])) }
+
+func (nodes *treeHeap[S]) Pop() any {
+	node := (*nodes)[len(*nodes)-1]
+	*nodes = (*nodes)[:len(*nodes)-1]
+	return node
+}
+
 func decodeTreeShape(reader io.Reader) (tree *HuffmanTree, err error) {
-	block_id_buff := make([]byte, 1)
+	return decodeSymbolTreeShape[byte](reader)
+}
 
-	if _, err = reader.Read(block_id_buff); err != nil {
-		return
-	}
+func decodeSymbolTreeShape[S Symbol](reader io.Reader) (tree *SymbolTree[S], err error) {
 
-	if block_id_buff[0] != BLOCK_ID_SHAPE {
-		err = errors.New("Unexpected block ID")
+	shape_buff_len, err := readBlockHeader(reader, BLOCK_ID_SHAPE)
+	if err != nil {
 		return
 	}
 
-	len_buff := make([]byte, 8)
-	if _, err = reader.Read(len_buff); err != nil {
+	max_leaves := maxLeaves[S]()
+
+	if shape_buff_len > uint64(2*max_leaves-1+7)/8 {
+		err = ErrInvalidShape
 		return
 	}
-	shape_buff_len := binary.LittleEndian.Uint64(len_buff)
 
 	var shape_buff bytes.Buffer
 
 	_, err = io.CopyN(&shape_buff, reader, int64(shape_buff_len))
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
-	tree = &HuffmanTree{}
+	tree = &SymbolTree[S]{}
 
-	stack := []**HuffmanTree{
+	stack := []**SymbolTree[S]{
 		&tree}
 
 	bits_reader := bits.NewReader(&shape_buff)
+	node_count := 0
+	internal_node_count := 0
 
 	for len(stack) > 0 {
 
@@ -180,32 +220,51 @@ func decodeTreeShape(reader io.Reader) (tree *HuffmanTree, err error) {
 		bit, err := bits_reader.ReadBit()
 
 		if err != nil {
-			return tree, err
+			// the shape ended before the tree was complete
+			return tree, ErrInvalidShape
 		}
+		node_count++
 
 		if bit {
-			(*visiting_node).left = &HuffmanTree{}
-			(*visiting_node).right = &HuffmanTree{}
+			// a tree with n leaves has n-1 internal nodes
+			internal_node_count++
+			if internal_node_count >= max_leaves {
+				return tree, ErrInvalidShape
+			}
+
+			(*visiting_node).left = &SymbolTree[S]{}
+			(*visiting_node).right = &SymbolTree[S]{}
 			stack = append(stack, &((*visiting_node).right))
 			stack = append(stack, &((*visiting_node).left))
 		}
 	}
+
+	// only zero padding may follow the tree
+	if uint64(node_count+7)/8 != shape_buff_len {
+		err = ErrInvalidShape
+		return
+	}
+
+	for bits_reader.CountUnflushedBits() > 0 {
+		if bit, _ := bits_reader.ReadBit(); bit {
+			err = ErrInvalidShape
+			return
+		}
+	}
 	return
 }
 
-func (node *HuffmanTree) encodeTreeShape(writer io.Writer) (err error) {
+func (node *SymbolTree[S]) encodeTreeShape(writer io.Writer) (err error) {
 
 	var shape_buff bytes.Buffer
 	shape_buff_writer := bits.NewWriter(&shape_buff)
 
 	node.encodeTreeShapeRecursive(shape_buff_writer)
-	shape_buff_writer.FlushBits()
-
-	writer.Write([]byte{BLOCK_ID_SHAPE})
+	if err = shape_buff_writer.FlushBits(); err != nil {
+		return
+	}
 
-	len_buff := make([]byte, 8)
-	binary.LittleEndian.PutUint64(len_buff, uint64(shape_buff.Len()))
-	if _, err = writer.Write(len_buff); err != nil {
+	if err = writeBlockHeader(writer, BLOCK_ID_SHAPE, uint64(shape_buff.Len())); err != nil {
 		return
 	}
 
@@ -213,50 +272,85 @@ func (node *HuffmanTree) encodeTreeShape(writer io.Writer) (err error) {
 	return
 }
 
-func (tree *HuffmanTree) decodeTreeLeaves(reader io.Reader) (err error) {
-	block_id_buff := make([]byte, 1)
+func (tree *SymbolTree[S]) decodeTreeLeaves(reader io.Reader) (err error) {
 
-	if _, err = reader.Read(block_id_buff); err != nil {
+	leaves_buff_len, err := readBlockHeader(reader, BLOCK_ID_LEAVES)
+	if err != nil {
 		return
 	}
 
-	if block_id_buff[0] != BLOCK_ID_LEAVES {
-		err = errors.New("Unexpected block ID")
-		return
-	}
+	leaf_count := uint64(tree.countLeaves())
 
-	len_buff := make([]byte, 8)
-	if _, err = reader.Read(len_buff); err != nil {
+	// variable length symbols take at least one byte
+	if symbol_size := symbolSize[S](); symbol_size > 0 && leaves_buff_len != uint64(symbol_size)*leaf_count ||
+		leaves_buff_len < leaf_count || leaves_buff_len > MAX_BODY_LEN {
+		err = ErrInvalidLeaves
 		return
 	}
-	leaves_buff_len := binary.LittleEndian.Uint64(len_buff)
 
-	leaves_buff := make([]byte, leaves_buff_len)
+	var leaves_buff bytes.Buffer
 
-	if _, err = reader.Read(leaves_buff); err != nil {
+	if _, err = io.CopyN(&leaves_buff, reader, int64(leaves_buff_len)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
-	var assign_leaves func(*HuffmanTree, *int)
+	var assign_leaves func(*SymbolTree[S]) error
 
-	assign_leaves = func(tree *HuffmanTree, index *int) {
+	assign_leaves = func(tree *SymbolTree[S]) (err error) {
 		if tree.left == nil {
-			tree.data = leaves_buff[*index]
-			*index++
+			tree.data, err = readSymbol[S](&leaves_buff)
 			return
 		}
 
-		assign_leaves(tree.left, index)
-		assign_leaves(tree.right, index)
+		if err = assign_leaves(tree.left); err != nil {
+			return
+		}
+		return assign_leaves(tree.right)
 	}
 
-	index := 0
-	assign_leaves(tree, &index)
+	if assign_leaves(tree) != nil || leaves_buff.Len() != 0 {
+		err = ErrInvalidLeaves
+	}
+	return
+}
 
+// Writes the shape and leaves blocks
+func (node *SymbolTree[S]) encodeTree(writer io.Writer) (err error) {
+	if err = node.encodeTreeShape(writer); err != nil {
+		return
+	}
+	err = node.encodeTreeLeaves(writer)
 	return
 }
 
-func (node *HuffmanTree) encodeTreeShapeRecursive(bits_writer *bits.Writer) {
+// Returns the size of the shape and leaves blocks
+func (node *SymbolTree[S]) headerSize() int64 {
+	var leaves_buff bytes.Buffer
+	node.encodeTreeLeavesRecursive(&leaves_buff)
+
+	node_count := 2*int64(node.countLeaves()) - 1
+	return 9 + (node_count+7)/8 + 9 + int64(leaves_buff.Len())
+}
+
+// Returns the length of the longest code of the tree
+func (node *SymbolTree[S]) depth() int {
+	if node.left == nil {
+		return 0
+	}
+	return 1 + max(node.left.depth(), node.right.depth())
+}
+
+func (node *SymbolTree[S]) countLeaves() int {
+	if node.left == nil {
+		return 1
+	}
+	return node.left.countLeaves() + node.right.countLeaves()
+}
+
+func (node *SymbolTree[S]) encodeTreeShapeRecursive(bits_writer *bits.Writer) {
 
 	if node.left == nil {
 		bits_writer.WriteBit(false)
@@ -269,16 +363,12 @@ func (node *HuffmanTree) encodeTreeShapeRecursive(bits_writer *bits.Writer) {
 	return
 }
 
-func (node *HuffmanTree) encodeTreeLeaves(writer io.Writer) (err error) {
+func (node *SymbolTree[S]) encodeTreeLeaves(writer io.Writer) (err error) {
 
 	var leaves_buff bytes.Buffer
 	node.encodeTreeLeavesRecursive(&leaves_buff)
 
-	writer.Write([]byte{BLOCK_ID_LEAVES})
-
-	len_buff := make([]byte, 8)
-	binary.LittleEndian.PutUint64(len_buff, uint64(leaves_buff.Len()))
-	if _, err = writer.Write(len_buff); err != nil {
+	if err = writeBlockHeader(writer, BLOCK_ID_LEAVES, uint64(leaves_buff.Len())); err != nil {
 		return
 	}
 
@@ -286,17 +376,17 @@ func (node *HuffmanTree) encodeTreeLeaves(writer io.Writer) (err error) {
 	return
 }
 
-func (node *HuffmanTree) encodeTreeLeavesRecursive(buff *bytes.Buffer) {
+func (node *SymbolTree[S]) encodeTreeLeavesRecursive(buff *bytes.Buffer) {
 	if node.left != nil {
 		node.left.encodeTreeLeavesRecursive(buff)
 		node.right.encodeTreeLeavesRecursive(buff)
 		return
 	}
 
-	buff.WriteByte(node.data)
+	writeSymbol(buff, node.data)
 }
 
-func (node *HuffmanTree) getEncodingTableRecursive(table *map[byte]bits.Slice, slice bits.Slice) {
+func (node *SymbolTree[S]) getEncodingTableRecursive(table *map[S]bits.Slice, slice bits.Slice) {
 	if node.left != nil {
 		left := slice
 		left.AppendBit(false)
@@ -311,98 +401,104 @@ func (node *HuffmanTree) getEncodingTableRecursive(table *map[byte]bits.Slice, s
 	(*table)[node.data] = slice
 }
 
-func (node *HuffmanTree) getEncodingTable() (table map[byte]bits.Slice) {
-	table = make(map[byte]bits.Slice, 20)
+func (node *SymbolTree[S]) getEncodingTable() (table map[S]bits.Slice) {
+	table = make(map[S]bits.Slice, 20)
 	node.getEncodingTableRecursive(&table, *bits.NewSlice(0, 0x0))
 	return
 }
 
-func (node *HuffmanTree) encodeBody(reader io.Reader, writer io.Writer, table map[byte]bits.Slice) (err error) {
-
-	var body_buff bytes.Buffer
-	bits_writer := bits.NewWriter(&body_buff)
-
-	key_buff := make([]byte, 1)
+func (node *SymbolTree[S]) encodeBody(symbols []S, writer io.Writer, table map[S]bits.Slice) (err error) {
+	return encodeBodyTables(symbols, writer, func(int) map[S]bits.Slice { return table })
+}
 
-	for {
+// Encodes a data block, in which the symbol at index i is coded with the encoding table returned by table_at.
+// The codes are counted first, so the body is written as it is produced.
+func encodeBodyTables[S Symbol](symbols []S, writer io.Writer, table_at func(i int) map[S]bits.Slice) (err error) {
 
-		if _, err = reader.Read(key_buff); err != nil {
-			if err != io.EOF {
-				return err
-			}
-			break
-		}
-
-		slice := table[key_buff[0]]
-		bits_writer.WriteSlice(&slice)
+	body_bits := int64(0)
+	for i, key := range symbols {
+		slice := table_at(i)[key]
+		body_bits += int64(slice.Len())
 	}
 
-	len_buff := make([]byte, 8)
-	binary.LittleEndian.PutUint64(len_buff, uint64(body_buff.Len()))
+	if err = writeBlockHeader(writer, BLOCK_ID_DATA, uint64(body_bits/8)); err != nil {
+		return
+	}
 
-	trailing_bit_count := byte(bits_writer.CountUnflushedBits())
-	bits_writer.FlushBits()
+	if _, err = writer.Write([]byte{byte(body_bits % 8)}); err != nil {
+		return
+	}
 
-	buffers := [][]byte{
-		[]byte{BLOCK_ID_DATA},
-		len_buff,
-		[]byte{trailing_bit_count},
-		body_buff.Bytes()}
+	bits_writer := bits.NewWriter(writer)
 
-	for _, buffer := range buffers {
-		if _, err = writer.Write(buffer); err != nil {
+	for i, key := range symbols {
+		slice := table_at(i)[key]
+		if err = bits_writer.WriteSlice(&slice); err != nil {
 			return
 		}
 	}
 
+	err = bits_writer.Close()
 	return
 }
 
-func (tree *HuffmanTree) decodeBody(reader io.Reader, writer io.Writer) (err error) {
-	block_id_buff := make([]byte, 1)
-
-	if _, err = reader.Read(block_id_buff); err != nil {
-		return
-	}
+// Decodes a data block, passing every symbol to emit
+func (tree *SymbolTree[S]) decodeBody(reader io.Reader, emit func(S) error) (err error) {
+	return decodeBodyTrees(reader, func() *SymbolTree[S] { return tree }, emit)
+}
 
-	if block_id_buff[0] != BLOCK_ID_DATA {
-		err = errors.New("Unexpected block ID")
-		return
-	}
+// Decodes a data block, in which every symbol is coded with the tree next_tree returns before it
+func decodeBodyTrees[S Symbol](reader io.Reader, next_tree func() *SymbolTree[S], emit func(S) error) (err error) {
 
-	len_buff := make([]byte, 8)
-	if _, err = reader.Read(len_buff); err != nil {
+	data_len, err := readBlockHeader(reader, BLOCK_ID_DATA)
+	if err != nil {
 		return
 	}
-	data_len := binary.LittleEndian.Uint64(len_buff)
 
 	trailing_bit_count_buff := make([]byte, 1)
-	if _, err = reader.Read(trailing_bit_count_buff); err != nil {
+	if _, err = io.ReadFull(reader, trailing_bit_count_buff); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
 
 	trailing_bit_count := trailing_bit_count_buff[0]
 
+	if trailing_bit_count > 7 || data_len > MAX_BODY_LEN {
+		err = ErrInvalidBody
+		return
+	}
+
 	bits_left := (8 * data_len) + uint64(trailing_bit_count)
 
 	if trailing_bit_count != 0 {
 		data_len++
 	}
 
-	var data_buff bytes.Buffer
+	// The body is read as it is decoded, buffered without reading past its end
+	bit_reader := bits.NewReader(bufio.NewReader(io.LimitReader(reader, int64(data_len))))
 
-	io.CopyN(&data_buff, reader, int64(data_len))
+	var node *SymbolTree[S]
 
-	bit_reader := bits.NewReader(&data_buff)
+	for bits_left != 0 {
 
-	node := tree
+		if node == nil {
+			node = next_tree()
 
-	for bits_left != 0 {
+			// a tree without internal nodes cannot encode anything
+			if node.left == nil {
+				return ErrInvalidBody
+			}
+		}
 
 		bits_left--
 		bit, err := bit_reader.ReadBit()
 
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return err
 		}
 
@@ -413,10 +509,49 @@ func (tree *HuffmanTree) decodeBody(reader io.Reader, writer io.Writer) (err err
 		}
 
 		if node.left == nil {
-			writer.Write([]byte{node.data})
-			node = tree
+			if err = emit(node.data); err != nil {
+				return err
+			}
+			node = nil
 		}
 	}
 
 	return
 }
+
+// Writes the block ID and length that start every block
+func writeBlockHeader(writer io.Writer, block_id byte, length uint64) (err error) {
+
+	header_buff := make([]byte, 9)
+	header_buff[0] = block_id
+	binary.LittleEndian.PutUint64(header_buff[1:], length)
+
+	_, err = writer.Write(header_buff)
+	return
+}
+
+// Reads the block ID and length that start every block.
+// Returns io.EOF if the reader has ended before the block, io.ErrUnexpectedEOF if it ends within it.
+func readBlockHeader(reader io.Reader, block_id byte) (length uint64, err error) {
+
+	block_id_buff := make([]byte, 1)
+	if _, err = io.ReadFull(reader, block_id_buff); err != nil {
+		return
+	}
+
+	if block_id_buff[0] != block_id {
+		err = ErrUnexpectedBlock
+		return
+	}
+
+	len_buff := make([]byte, 8)
+	if _, err = io.ReadFull(reader, len_buff); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	length = binary.LittleEndian.Uint64(len_buff)
+	return
+}
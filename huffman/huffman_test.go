@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"dense/bits"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"math/rand"
 	"reflect"
 	"testing"
+	"testing/iotest"
 )
 
 func TestGenerateTree(t *testing.T) {
@@ -276,7 +280,7 @@ func TestHuffmanTreeEncodeBody(t *testing.T) {
 	var input, output, expected_output bytes.Buffer
 
 	// empty input
-	err := tree.encodeBody(&input, &output, table)
+	err := tree.encodeBody(input.Bytes(), &output, table)
 
 	if err != nil {
 		t.Errorf("encodeTreeLeaves failed. Got error %s", err)
@@ -301,7 +305,7 @@ func TestHuffmanTreeEncodeBody(t *testing.T) {
 	// or more readable: 0100 1001 0000 0000
 	input.Write([]byte{0xFF, 0xFF, 0xFF})
 
-	err = tree.encodeBody(&input, &output, table)
+	err = tree.encodeBody(input.Bytes(), &output, table)
 
 	if err != nil {
 		t.Errorf("encodeTreeLeaves failed. Got error %s", err)
@@ -585,3 +589,410 @@ func TestHuffmanEncodeDecode(t *testing.T) {
 	}
 
 }
+
+func TestHuffmanDecodeTreeShapeInvalid(t *testing.T) {
+
+	shape_block := func(shape_len uint64, shape []byte) *bytes.Buffer {
+		var buff bytes.Buffer
+		len_buff := make([]byte, 8)
+		binary.LittleEndian.PutUint64(len_buff, shape_len)
+		buff.WriteByte(BLOCK_ID_SHAPE)
+		buff.Write(len_buff)
+		buff.Write(shape)
+		return &buff
+	}
+
+	// shape longer than any valid tree
+	_, err := decodeTreeShape(shape_block(1<<62, nil))
+	if err != ErrInvalidShape {
+		t.Errorf("Expected '%s', got %v", ErrInvalidShape, err)
+	}
+
+	// only internal nodes, tree is incomplete
+	_, err = decodeTreeShape(shape_block(1, []byte{0xFF}))
+	if err != ErrInvalidShape {
+		t.Errorf("Expected '%s', got %v", ErrInvalidShape, err)
+	}
+
+	// leaf followed by non-zero padding
+	_, err = decodeTreeShape(shape_block(1, []byte{0x30}))
+	if err != ErrInvalidShape {
+		t.Errorf("Expected '%s', got %v", ErrInvalidShape, err)
+	}
+
+	// complete tree followed by an extra byte
+	_, err = decodeTreeShape(shape_block(2, []byte{0x80, 0x00}))
+	if err != ErrInvalidShape {
+		t.Errorf("Expected '%s', got %v", ErrInvalidShape, err)
+	}
+
+	// degenerate tree with more than 256 leaves: 300 times 1 followed by 301 times 0
+	var shape_buff bytes.Buffer
+	shape_writer := bits.NewWriter(&shape_buff)
+	for i := 0; i < 601; i++ {
+		shape_writer.WriteBit(i < 300)
+	}
+	shape_writer.FlushBits()
+
+	_, err = decodeTreeShape(shape_block(MAX_SHAPE_LEN, shape_buff.Bytes()[:MAX_SHAPE_LEN]))
+	if err != ErrInvalidShape {
+		t.Errorf("Expected '%s', got %v", ErrInvalidShape, err)
+	}
+}
+
+func TestHuffmanTreeDecodeLeavesInvalid(t *testing.T) {
+
+	tree := &HuffmanTree{
+		left:  &HuffmanTree{},
+		right: &HuffmanTree{}}
+
+	for _, leaves_len := range []uint64{0, 1, 3, 1 << 62} {
+		var buff bytes.Buffer
+		len_buff := make([]byte, 8)
+		binary.LittleEndian.PutUint64(len_buff, leaves_len)
+		buff.WriteByte(BLOCK_ID_LEAVES)
+		buff.Write(len_buff)
+		buff.Write([]byte{0x01, 0x02})
+
+		if err := tree.decodeTreeLeaves(&buff); err != ErrInvalidLeaves {
+			t.Errorf("Expected '%s', got %v", ErrInvalidLeaves, err)
+		}
+	}
+}
+
+func TestHuffmanTreeDecodeBodyInvalid(t *testing.T) {
+
+	body_block := func(data_len uint64, trailing_bit_count byte, data []byte) *bytes.Buffer {
+		var buff bytes.Buffer
+		len_buff := make([]byte, 8)
+		binary.LittleEndian.PutUint64(len_buff, data_len)
+		buff.WriteByte(BLOCK_ID_DATA)
+		buff.Write(len_buff)
+		buff.WriteByte(trailing_bit_count)
+		buff.Write(data)
+		return &buff
+	}
+
+	tree := &HuffmanTree{
+		left:  &HuffmanTree{data: 0x1},
+		right: &HuffmanTree{data: 0x2}}
+
+	var output bytes.Buffer
+
+	if err := tree.decodeBody(body_block(1, 8, []byte{0x0, 0x0}), byteWriter(&output)); err != ErrInvalidBody {
+		t.Errorf("Expected '%s', got %v", ErrInvalidBody, err)
+	}
+
+	if err := tree.decodeBody(body_block(1<<62, 0, []byte{0x0}), byteWriter(&output)); err != ErrInvalidBody {
+		t.Errorf("Expected '%s', got %v", ErrInvalidBody, err)
+	}
+
+	// a tree that consists of only the root cannot decode any bit
+	root := &HuffmanTree{}
+	if err := root.decodeBody(body_block(1, 0, []byte{0x0}), byteWriter(&output)); err != ErrInvalidBody {
+		t.Errorf("Expected '%s', got %v", ErrInvalidBody, err)
+	}
+}
+
+// Readers which return less data than requested, or data along with an error
+var short_readers = map[string]func(io.Reader) io.Reader{
+	"OneByteReader": iotest.OneByteReader,
+	"HalfReader":    iotest.HalfReader,
+	"DataErrReader": iotest.DataErrReader,
+	"OneByteDataErrReader": func(reader io.Reader) io.Reader {
+		return iotest.DataErrReader(iotest.OneByteReader(reader))
+	}}
+
+func TestHuffmanShortReads(t *testing.T) {
+
+	input := make([]byte, 10000)
+	for i := range input {
+		input[i] = byte(rand.Intn(20))
+	}
+
+	for name, short_reader := range short_readers {
+
+		options := DefaultOptions()
+		options.BlockSize = 3000
+
+		var compressed, output bytes.Buffer
+		err := EncodeWithOptions(short_reader(bytes.NewReader(input)), &compressed, options)
+
+		if err != nil {
+			t.Errorf("%s: got error %s", name, err)
+		}
+
+		err = Decode(short_reader(bytes.NewReader(compressed.Bytes())), &output)
+
+		if err != nil {
+			t.Errorf("%s: got error %s", name, err)
+		}
+
+		if !bytes.Equal(input, output.Bytes()) {
+			t.Errorf("%s: decompressed output differs from input", name)
+		}
+	}
+}
+
+func TestHuffmanReadErrors(t *testing.T) {
+
+	var compressed, output bytes.Buffer
+	if err := Encode(bytes.NewBufferString("dense"), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	// errors of the underlying reader are returned
+	err := Decode(iotest.TimeoutReader(iotest.OneByteReader(&compressed)), &output)
+	if err != iotest.ErrTimeout {
+		t.Errorf("Expected '%s', got %v", iotest.ErrTimeout, err)
+	}
+
+	err = Encode(iotest.ErrReader(iotest.ErrTimeout), &output)
+	if err != iotest.ErrTimeout {
+		t.Errorf("Expected '%s', got %v", iotest.ErrTimeout, err)
+	}
+}
+
+func TestHuffmanTruncated(t *testing.T) {
+
+	input := []byte("this is some content")
+
+	var compressed bytes.Buffer
+	if err := Encode(bytes.NewReader(input), &compressed); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, _ := List(bytes.NewReader(compressed.Bytes()))
+	trailer_offset := info.Blocks[len(info.Blocks)-1].Offset
+
+	for length := 0; length < compressed.Len(); length++ {
+
+		truncated := compressed.Bytes()[:length]
+
+		for name, short_reader := range short_readers {
+			var output bytes.Buffer
+			err := Decode(short_reader(bytes.NewReader(truncated)), &output)
+
+			// Streams without trailer are valid, for backward compatibility
+			if int64(length) == trailer_offset {
+				if err != nil || !bytes.Equal(input, output.Bytes()) {
+					t.Errorf("%s: length %d: got error %v", name, length, err)
+				}
+				continue
+			}
+
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("%s: length %d: expected '%s', got %v", name, length, io.ErrUnexpectedEOF, err)
+			}
+		}
+	}
+}
+
+func TestHuffmanTruncatedBlocks(t *testing.T) {
+
+	input := make([]byte, 3000)
+	for i := range input {
+		input[i] = byte(rand.Intn(16))
+	}
+
+	options := DefaultOptions()
+	options.BlockSize = 1000
+
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, _ := List(bytes.NewReader(compressed.Bytes()))
+
+	// a stream of several blocks cut at any block boundary is truncated, even before the trailer
+	for i, block := range info.Blocks[1:] {
+		truncated := compressed.Bytes()[:block.Offset]
+
+		var output bytes.Buffer
+		err := Decode(bytes.NewReader(truncated), &output)
+
+		// except after the first block, which looks like a stream written before the trailer
+		if i+1 == 3 {
+			if err != nil || !bytes.Equal(input[:1000], output.Bytes()) {
+				t.Errorf("Length %d: got error %v", block.Offset, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Length %d: expected '%s', got %v", block.Offset, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+// Returns a function writing every decoded byte to writer
+func byteWriter(writer io.Writer) func(byte) error {
+	buff := make([]byte, 1)
+	return func(b byte) (err error) {
+		buff[0] = b
+		_, err = writer.Write(buff)
+		return
+	}
+}
+
+// Text with a skewed byte distribution, as used by the benchmarks
+func benchmarkInput() []byte {
+	words := []string{"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog", "dense", "huffman"}
+	random := rand.New(rand.NewSource(1))
+
+	var input bytes.Buffer
+	for input.Len() < 1<<20 {
+		input.WriteString(words[random.Intn(len(words))])
+		input.WriteByte(" \n"[random.Intn(2)])
+	}
+	return input.Bytes()
+}
+
+func BenchmarkEncode(b *testing.B) {
+
+	input := benchmarkInput()
+
+	for _, level := range []int{MIN_LEVEL, DEFAULT_LEVEL, MAX_LEVEL} {
+		b.Run(fmt.Sprintf("level%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			for i := 0; i < b.N; i++ {
+				if err := EncodeWithOptions(bytes.NewReader(input), io.Discard, LevelOptions(level)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+
+	input := benchmarkInput()
+
+	for _, level := range []int{MIN_LEVEL, DEFAULT_LEVEL, MAX_LEVEL} {
+		var compressed bytes.Buffer
+		EncodeWithOptions(bytes.NewReader(input), &compressed, LevelOptions(level))
+
+		b.Run(fmt.Sprintf("level%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			for i := 0; i < b.N; i++ {
+				if err := Decode(bytes.NewReader(compressed.Bytes()), io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeBodyAllocs(t *testing.T) {
+
+	input := benchmarkInput()
+	tree, _ := generateTree(bytes.NewReader(input))
+	table := tree.getEncodingTable()
+
+	// the allocations do not depend on the number of symbols
+	allocs := func(symbols []byte) float64 {
+		return testing.AllocsPerRun(10, func() {
+			tree.encodeBody(symbols, io.Discard, table)
+		})
+	}
+
+	short, long := allocs(input[:100]), allocs(input)
+	if short != long {
+		t.Errorf("Expected %.1f allocations for %d symbols, got %.1f", short, len(input), long)
+	}
+}
+
+func BenchmarkEncodeBody(b *testing.B) {
+
+	input := benchmarkInput()
+	tree, _ := generateTree(bytes.NewReader(input))
+	table := tree.getEncodingTable()
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := tree.encodeBody(input, io.Discard, table); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeBodyStreaming(t *testing.T) {
+
+	input := bytes.Repeat([]byte("streaming "), 10000)
+	tree, _ := generateTree(bytes.NewReader(input))
+
+	var body bytes.Buffer
+	if err := tree.encodeBody(input, &body, tree.getEncodingTable()); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// symbols are emitted before the rest of the body is written
+	reader, writer := io.Pipe()
+	emitted := make(chan byte, len(input))
+	done := make(chan error)
+
+	go func() {
+		done <- tree.decodeBody(reader, func(b byte) error {
+			emitted <- b
+			return nil
+		})
+	}()
+
+	half := body.Len() / 2
+	writer.Write(body.Bytes()[:half])
+
+	if b := <-emitted; b != input[0] {
+		t.Errorf("Expected 0x%x, got 0x%x", input[0], b)
+	}
+
+	writer.Write(body.Bytes()[half:])
+	if err := <-done; err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if len(emitted) != len(input)-1 {
+		t.Errorf("Expected %d symbols, got %d", len(input), len(emitted)+1)
+	}
+
+	// a body ending early is reported
+	err := tree.decodeBody(bytes.NewReader(body.Bytes()[:half]), func(byte) error { return nil })
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// Writer counting the calls to Write
+type writeCounter struct {
+	writes int
+}
+
+func (counter *writeCounter) Write(buff []byte) (int, error) {
+	counter.writes++
+	return len(buff), nil
+}
+
+func TestDecodeBuffered(t *testing.T) {
+
+	input := benchmarkInput()
+
+	var compressed bytes.Buffer
+	if err := Encode(bytes.NewReader(input), &compressed); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// the output is written in chunks, not byte by byte
+	counter := &writeCounter{}
+	if err := Decode(&compressed, counter); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if max_writes := len(input)/OUTPUT_BUFFER_SIZE + 1; counter.writes > max_writes {
+		t.Errorf("Expected at most %d writes, got %d", max_writes, counter.writes)
+	}
+}
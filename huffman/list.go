@@ -0,0 +1,165 @@
+package huffman
+
+import (
+	"context"
+	"io"
+)
+
+type BlockInfo struct {
+	ID               byte
+	Offset           int64
+	Length           int64
+	UncompressedSize int64
+}
+
+type StreamInfo struct {
+	Method           string
+	Blocks           []BlockInfo
+	CompressedSize   int64
+	UncompressedSize int64
+	HasChecksum      bool
+	ChecksumType     byte
+	Checksum         uint64
+	HasDictionary    bool
+	DictionaryID     uint32
+
+	// Number of concatenated streams, the checksum is that of the last one
+	Members int
+
+	// Header of the first member, nil if it has none
+	Header *Header
+
+	// Whether the stream is encrypted. Blocks within encrypted members
+	// are listed at their offsets in the decrypted data.
+	Encrypted bool
+
+	// Whether the stream has parity, and the number of damaged bytes it corrected. Blocks within
+	// members with parity are listed at their offsets in the corrected data.
+	HasParity bool
+	Corrected int64
+}
+
+// Returns a human readable name of a block ID
+func BlockName(id byte) string {
+	switch id {
+	case BLOCK_ID_SHAPE:
+		return "shape"
+	case BLOCK_ID_LEAVES:
+		return "leaves"
+	case BLOCK_ID_DATA:
+		return "data"
+	case BLOCK_ID_TRAILER:
+		return "trailer"
+	case BLOCK_ID_TABLE:
+		return "table"
+	case BLOCK_ID_DICTIONARY:
+		return "dictionary"
+	case BLOCK_ID_CONTEXT:
+		return "context"
+	case BLOCK_ID_SYNC:
+		return "sync"
+	case BLOCK_ID_HEADER:
+		return "header"
+	case BLOCK_ID_ENCRYPTION:
+		return "encryption"
+	case BLOCK_ID_SEALED:
+		return "sealed"
+	case BLOCK_ID_PARITY:
+		return "parity"
+	case BLOCK_ID_RESYNC:
+		return "resync"
+	}
+	return "unknown"
+}
+
+// Returns a human readable name of a checksum type
+func ChecksumName(checksum_type byte) string {
+	switch checksum_type {
+	case CHECKSUM_NONE:
+		return "none"
+	case CHECKSUM_CRC32:
+		return "crc32"
+	case CHECKSUM_CRC64:
+		return "crc64"
+	}
+	return "unknown"
+}
+
+// Reads a whole stream and describes its blocks.
+// The returned info is filled as far as the stream could be read, even when an error is returned.
+func List(reader io.Reader) (info *StreamInfo, err error) {
+	return ListWithOptions(reader, DecodeOptions{})
+}
+
+// Describes the blocks of a stream, which may reference the tables in options
+func ListWithOptions(reader io.Reader, options DecodeOptions) (info *StreamInfo, err error) {
+	info = &StreamInfo{
+		Method: "huffman"}
+
+	err = newDecoder(context.Background(), reader, io.Discard, options, info).decode()
+	return
+}
+
+func (info *StreamInfo) addBlock(id byte, offset, end, uncompressed_size int64) {
+	if info == nil {
+		return
+	}
+
+	info.Blocks = append(info.Blocks, BlockInfo{
+		ID:               id,
+		Offset:           offset,
+		Length:           end - offset,
+		UncompressedSize: uncompressed_size})
+
+	info.CompressedSize = end
+	info.UncompressedSize += uncompressed_size
+}
+
+func (info *StreamInfo) addMember() {
+	if info != nil {
+		info.Members++
+	}
+}
+
+func (info *StreamInfo) setHeader(header *Header) {
+	if info != nil && info.Header == nil && info.Members == 1 {
+		info.Header = header
+	}
+}
+
+func (info *StreamInfo) setEncrypted() {
+	if info != nil {
+		info.Encrypted = true
+	}
+}
+
+func (info *StreamInfo) addCorrected(corrected int64) {
+	if info != nil {
+		info.HasParity = true
+		info.Corrected += corrected
+	}
+}
+
+func (info *StreamInfo) setDictionary(id uint32) {
+	if info == nil {
+		return
+	}
+
+	info.HasDictionary = true
+	info.DictionaryID = id
+}
+
+func (info *StreamInfo) setTrailer(checksum_type byte, digest []byte) {
+	if info == nil || checksum_type == CHECKSUM_NONE {
+		return
+	}
+
+	info.HasChecksum = true
+	info.ChecksumType = checksum_type
+
+	// The digest is stored in little endian byte order
+	info.Checksum = 0
+	for i := len(digest) - 1; i >= 0; i-- {
+		info.Checksum = info.Checksum<<8 | uint64(digest[i])
+	}
+}
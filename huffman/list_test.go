@@ -0,0 +1,74 @@
+package huffman
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+
+	var input, output bytes.Buffer
+	input.WriteString("this is some content")
+
+	if err := Encode(&input, &output); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	compressed_size := int64(output.Len())
+
+	info, err := List(&output)
+
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	expected_ids := []byte{BLOCK_ID_SHAPE, BLOCK_ID_LEAVES, BLOCK_ID_DATA, BLOCK_ID_TRAILER}
+
+	if len(info.Blocks) != len(expected_ids) {
+		t.Fatalf("Expected %d blocks, got %v", len(expected_ids), info.Blocks)
+	}
+
+	offset := int64(0)
+	for i, block := range info.Blocks {
+		if block.ID != expected_ids[i] || block.Offset != offset {
+			t.Errorf("Unexpected block %+v at index %d", block, i)
+		}
+		offset += block.Length
+	}
+
+	if info.CompressedSize != compressed_size || offset != compressed_size {
+		t.Errorf("Expected compressed size %d, got %d", compressed_size, info.CompressedSize)
+	}
+
+	if info.UncompressedSize != 20 {
+		t.Errorf("Expected uncompressed size 20, got %d", info.UncompressedSize)
+	}
+
+	if !info.HasChecksum || info.ChecksumType != CHECKSUM_CRC32 {
+		t.Errorf("Unexpected checksum info %+v", info)
+	}
+}
+
+func TestListCorrupted(t *testing.T) {
+
+	var input, output bytes.Buffer
+	input.WriteString("this is some content")
+
+	if err := Encode(&input, &output); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	// flip a bit of the checksum
+	corrupted := output.Bytes()
+	corrupted[len(corrupted)-1] ^= 0x1
+
+	info, err := List(bytes.NewReader(corrupted))
+
+	if err != ErrChecksum {
+		t.Errorf("Expected '%s', got %v", ErrChecksum, err)
+	}
+
+	if len(info.Blocks) != 4 {
+		t.Errorf("Expected 4 blocks, got %v", info.Blocks)
+	}
+}
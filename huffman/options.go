@@ -0,0 +1,182 @@
+package huffman
+
+import (
+	"errors"
+)
+
+const (
+	MIN_LEVEL     = 1
+	MAX_LEVEL     = 9
+	DEFAULT_LEVEL = 6
+)
+
+const (
+	// Checksum written if Options.Checksum is zero
+	DEFAULT_CHECKSUM = CHECKSUM_CRC32
+
+	// Value of Options.Checksum writing no checksum, as zero selects DEFAULT_CHECKSUM
+	CHECKSUM_OFF = 0x80
+)
+
+// Number of input bytes of a block kept in memory if Options.MaxBuffer is zero
+const DEFAULT_MAX_BUFFER = 64 << 20
+
+// Number of input bytes per block for each level.
+// Lower levels use smaller blocks, which bounds memory use and allows encoding blocks concurrently.
+// Zero means the whole input is encoded as one block.
+var level_block_sizes = [MAX_LEVEL + 1]int64{
+	1: 64 << 10,
+	2: 128 << 10,
+	3: 256 << 10,
+	4: 512 << 10,
+	5: 1 << 20}
+
+// Context order for each level. Levels above DEFAULT_LEVEL code every byte
+// with a tree depending on the byte preceding it, where that is smaller.
+var level_context_orders = [MAX_LEVEL + 1]int{
+	7: 1,
+	8: 1,
+	9: 1}
+
+type Options struct {
+	// Compression level from MIN_LEVEL to MAX_LEVEL, DEFAULT_LEVEL if zero
+	Level int
+
+	// Number of input bytes per block, overrides the block size of the level if not zero
+	BlockSize int64
+
+	// CHECKSUM_CRC32, CHECKSUM_CRC64 or CHECKSUM_OFF, DEFAULT_CHECKSUM if zero
+	Checksum byte
+
+	// Maximum number of blocks encoded in parallel, one if zero
+	Concurrency int
+
+	// Optional progress callback, called after every write and every encoded block
+	Progress ProgressFunc
+
+	// Optional pre-computed table, used for blocks where referencing it is smaller than embedding a tree
+	Table *Table
+
+	// Optional dictionary, which is then needed to decompress the stream. Cannot be combined with Table.
+	Dictionary *Dictionary
+
+	// Optional header stored at the start of the stream
+	Header *Header
+
+	// Optional key of KEY_SIZE bytes or passphrase to encrypt the stream with, using AES-256-GCM
+	Key        []byte
+	Passphrase string
+
+	// Optional percentage of damaged bytes per frame of the stream that can be corrected, up to MAX_PARITY.
+	// Adds Reed-Solomon parity to the stream, which Decode uses to correct damaged bytes.
+	Parity int
+
+	// Writes a resync block before every block, so DecodeOptions.Recover can skip damaged blocks.
+	// Blocks then do not reuse the trees of the previous block.
+	Recoverable bool
+
+	// Maximum number of input bytes of a block kept in memory, DEFAULT_MAX_BUFFER if zero.
+	// Larger blocks are read twice if the input can seek, or are spilled to a temporary file otherwise.
+	MaxBuffer int64
+}
+
+// Returns the options used by Encode
+func DefaultOptions() Options {
+	return Options{
+		Level:       DEFAULT_LEVEL,
+		Checksum:    DEFAULT_CHECKSUM,
+		Concurrency: 1}
+}
+
+// Returns the options for a compression level, with otherwise default settings
+func LevelOptions(level int) Options {
+	options := DefaultOptions()
+	options.Level = level
+	return options
+}
+
+// Checks the options and returns the block size to use
+func (options *Options) validate() (block_size int64, err error) {
+
+	level := options.Level
+	if level == 0 {
+		level = DEFAULT_LEVEL
+	}
+
+	if level < MIN_LEVEL || level > MAX_LEVEL {
+		err = errors.New("Invalid compression level")
+		return
+	}
+
+	if options.BlockSize < 0 {
+		err = errors.New("Invalid block size")
+		return
+	}
+
+	if options.Concurrency < 0 {
+		err = errors.New("Invalid concurrency")
+		return
+	}
+
+	if options.MaxBuffer < 0 {
+		err = errors.New("Invalid maximum buffer size")
+		return
+	}
+
+	if options.Parity < 0 || options.Parity > MAX_PARITY {
+		err = errors.New("Invalid parity percentage")
+		return
+	}
+
+	if options.Table != nil && options.Dictionary != nil {
+		err = errors.New("Table and Dictionary cannot be combined")
+		return
+	}
+
+	if options.Key != nil && options.Passphrase != "" {
+		err = errors.New("Key and Passphrase cannot be combined")
+		return
+	}
+
+	if options.Key != nil && len(options.Key) != KEY_SIZE {
+		err = ErrInvalidKey
+		return
+	}
+
+	if _, err = newChecksum(options.checksumType()); err != nil {
+		return
+	}
+
+	block_size = options.BlockSize
+	if block_size == 0 {
+		block_size = level_block_sizes[level]
+	}
+	return
+}
+
+// Returns the checksum type stored in the trailer
+func (options *Options) checksumType() byte {
+	switch options.Checksum {
+	case 0:
+		return DEFAULT_CHECKSUM
+	case CHECKSUM_OFF:
+		return CHECKSUM_NONE
+	}
+	return options.Checksum
+}
+
+// Returns the context order of the level, the options must be valid
+func (options *Options) contextOrder() int {
+	if options.Level == 0 {
+		return level_context_orders[DEFAULT_LEVEL]
+	}
+	return level_context_orders[options.Level]
+}
+
+// Returns the maximum number of input bytes of a block kept in memory
+func (options *Options) maxBuffer() int64 {
+	if options.MaxBuffer == 0 {
+		return DEFAULT_MAX_BUFFER
+	}
+	return options.MaxBuffer
+}
@@ -0,0 +1,45 @@
+package huffman
+
+import (
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+
+	options := Options{}
+	block_size, err := options.validate()
+
+	if err != nil || block_size != 0 {
+		t.Errorf("Got block size %d and error %v", block_size, err)
+	}
+
+	options = LevelOptions(1)
+	block_size, err = options.validate()
+
+	if err != nil || block_size != level_block_sizes[1] {
+		t.Errorf("Got block size %d and error %v", block_size, err)
+	}
+
+	options.BlockSize = 1234
+	block_size, err = options.validate()
+
+	if err != nil || block_size != 1234 {
+		t.Errorf("Got block size %d and error %v", block_size, err)
+	}
+
+	invalid := []Options{
+		Options{Level: -1},
+		Options{Level: MAX_LEVEL + 1},
+		Options{BlockSize: -1},
+		Options{Concurrency: -1},
+		Options{MaxBuffer: -1},
+		Options{Parity: -1},
+		Options{Parity: MAX_PARITY + 1},
+		Options{Checksum: 0xFF}}
+
+	for _, options := range invalid {
+		if _, err = options.validate(); err == nil {
+			t.Errorf("Expected error for %+v, got nil", options)
+		}
+	}
+}
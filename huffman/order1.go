@@ -0,0 +1,228 @@
+package huffman
+
+import (
+	"dense/bits"
+	"errors"
+	"io"
+)
+
+// Number of contexts of an order-1 block, one per value of the preceding byte
+const CONTEXT_COUNT = 256
+
+var ErrInvalidContext = errors.New("Invalid context block")
+
+// Trees of an order-1 block. Every byte is coded with the tree of the context of the byte preceding it,
+// the first byte of a block with the tree of context zero. Contexts map to trees by index,
+// contexts for which a tree of their own does not pay off share one tree.
+type contextTrees struct {
+	indexes [CONTEXT_COUNT]byte
+	trees   []*HuffmanTree
+}
+
+// Number of occurrences of every byte per preceding byte
+type contextCounts [CONTEXT_COUNT][256]int64
+
+// Counts the bytes of data, of which the first is preceded by previous. Returns the last byte.
+func (counts *contextCounts) count(data []byte, previous byte) byte {
+	for _, b := range data {
+		counts[previous][b]++
+		previous = b
+	}
+	return previous
+}
+
+// Counts the bytes read until the reader ends and returns their number
+func (counts *contextCounts) countReader(reader io.Reader) (size int64, err error) {
+
+	buff := make([]byte, 1<<16)
+	previous := byte(0)
+
+	for {
+		var read_bytes int
+		read_bytes, err = reader.Read(buff)
+
+		// a reader may return data along with an error
+		previous = counts.count(buff[:read_bytes], previous)
+		size += int64(read_bytes)
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+// Returns the number of occurrences of every byte, regardless of the preceding byte
+func (counts *contextCounts) totals() (totals map[byte]int64) {
+
+	totals = make(map[byte]int64, 256)
+	for context := range counts {
+		for b, count := range counts[context] {
+			if count > 0 {
+				totals[byte(b)] += count
+			}
+		}
+	}
+	return
+}
+
+// Encoding table for every value of the preceding byte
+type byteTables [CONTEXT_COUNT]map[byte]bits.Slice
+
+// Returns the number of bits coding the counted bytes with tables takes.
+// Ok is false if a counted byte has no code.
+func (counts *contextCounts) bodyBits(tables *byteTables) (body_bits int64, ok bool) {
+	for context := range counts {
+		for b, count := range counts[context] {
+			if count == 0 {
+				continue
+			}
+
+			slice, found := tables[context][byte(b)]
+			if !found {
+				return
+			}
+			body_bits += count * int64(slice.Len())
+		}
+	}
+	ok = true
+	return
+}
+
+// Builds the trees of an order-1 block and returns the size of the context block and trees,
+// and the number of bits of the data block body.
+// The shared tree is estimated to code like fallback_table, the order-0 encoding table of the data.
+func newContextTrees(counts *contextCounts, fallback_table map[byte]bits.Slice) (contexts *contextTrees,
+	header_size int64, body_bits int64) {
+
+	context_counts := make([]map[byte]int64, CONTEXT_COUNT)
+	for context := range context_counts {
+		context_counts[context] = make(map[byte]int64)
+		for b, count := range counts[context] {
+			if count > 0 {
+				context_counts[context][byte(b)] = count
+			}
+		}
+	}
+
+	contexts = &contextTrees{}
+	shared_counts := make(map[byte]int64)
+	var shared_contexts []int
+
+	for context, counts := range context_counts {
+		if len(counts) == 0 {
+			continue
+		}
+
+		tree := generateTreeFromCounts(counts)
+		tree_bits, _ := bodyBits(counts, tree.getEncodingTable())
+		shared_bits, ok := bodyBits(counts, fallback_table)
+
+		if ok && 8*tree.headerSize()+tree_bits >= shared_bits {
+			shared_contexts = append(shared_contexts, context)
+			for key, count := range counts {
+				shared_counts[key] += count
+			}
+			continue
+		}
+
+		contexts.indexes[context] = byte(len(contexts.trees))
+		contexts.trees = append(contexts.trees, tree)
+	}
+
+	if len(shared_contexts) > 0 {
+		for _, context := range shared_contexts {
+			contexts.indexes[context] = byte(len(contexts.trees))
+		}
+		contexts.trees = append(contexts.trees, generateTreeFromCounts(shared_counts))
+	}
+
+	for context, counts := range context_counts {
+		tree_bits, _ := bodyBits(counts, contexts.trees[contexts.indexes[context]].getEncodingTable())
+		body_bits += tree_bits
+	}
+
+	header_size = 9 + CONTEXT_COUNT
+	for _, tree := range contexts.trees {
+		header_size += tree.headerSize()
+	}
+	return
+}
+
+// Writes the context block and the trees
+func (contexts *contextTrees) encodeHeader(writer io.Writer) (err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_CONTEXT, CONTEXT_COUNT); err != nil {
+		return
+	}
+
+	if _, err = writer.Write(contexts.indexes[:]); err != nil {
+		return
+	}
+
+	for _, tree := range contexts.trees {
+		if err = tree.encodeTree(writer); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the encoding table of every context
+func (contexts *contextTrees) encodingTables() (tables *byteTables) {
+
+	tree_tables := make([]map[byte]bits.Slice, len(contexts.trees))
+	for i, tree := range contexts.trees {
+		tree_tables[i] = tree.getEncodingTable()
+	}
+
+	tables = &byteTables{}
+	for context, index := range contexts.indexes {
+		tables[context] = tree_tables[index]
+	}
+	return
+}
+
+// Reads the context block and returns the number of trees following it
+func (contexts *contextTrees) decodeIndexes(reader io.Reader) (tree_count int, err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_CONTEXT)
+	if err != nil {
+		return
+	}
+
+	if length != CONTEXT_COUNT {
+		err = ErrInvalidContext
+		return
+	}
+
+	if _, err = io.ReadFull(reader, contexts.indexes[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	for _, index := range contexts.indexes {
+		if int(index) >= tree_count {
+			tree_count = int(index) + 1
+		}
+	}
+	return
+}
+
+func (contexts *contextTrees) decodeBody(reader io.Reader, emit func(byte) error) (err error) {
+
+	previous := byte(0)
+
+	next_tree := func() *HuffmanTree {
+		return contexts.trees[contexts.indexes[previous]]
+	}
+
+	return decodeBodyTrees(reader, next_tree, func(b byte) error {
+		previous = b
+		return emit(b)
+	})
+}
@@ -0,0 +1,116 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeOrder1(t *testing.T) {
+
+	// text in which the byte following a letter mostly depends on that letter
+	var text strings.Builder
+	words := []string{"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog"}
+	for i := 0; i < 20000; i++ {
+		text.WriteString(words[rand.Intn(len(words))])
+		text.WriteByte(" \n"[rand.Intn(2)])
+	}
+
+	// a few rare contexts
+	text.WriteString("ABC DEF GHI")
+	input := []byte(text.String())
+
+	var order0, order1, output bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &order0, LevelOptions(6)); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+	if err := EncodeWithOptions(bytes.NewReader(input), &order1, LevelOptions(9)); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if 10*order1.Len() > 8*order0.Len() {
+		t.Errorf("Expected order-1 to be at least 20%% smaller, got %d and %d bytes", order1.Len(), order0.Len())
+	}
+
+	info, err := List(bytes.NewReader(order1.Bytes()))
+	if err != nil || info.Blocks[0].ID != BLOCK_ID_CONTEXT {
+		t.Fatalf("Expected context block, got %+v and error %v", info, err)
+	}
+
+	// contexts that occur rarely share a tree
+	tree, _ := generateTree(bytes.NewReader(input))
+	counts := &contextCounts{}
+	counts.count(input, 0)
+	contexts, _, _ := newContextTrees(counts, tree.getEncodingTable())
+
+	shared_index := contexts.indexes['A']
+	for _, context := range []byte("BCDEFGH") {
+		if contexts.indexes[context] != shared_index {
+			t.Errorf("Expected context '%c' to use tree %d, got %d", context, shared_index,
+				contexts.indexes[context])
+		}
+	}
+
+	if contexts.indexes['t'] == shared_index || contexts.indexes['t'] == contexts.indexes['h'] {
+		t.Errorf("Expected contexts 't' and 'h' to have their own tree")
+	}
+
+	if err = Decode(&order1, &output); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Errorf("Decompressed output differs from input")
+	}
+}
+
+func TestEncodeOrder1NotUsed(t *testing.T) {
+
+	// random bytes do not depend on the preceding byte
+	input := make([]byte, 100000)
+	for i := range input {
+		input[i] = byte(rand.Intn(256))
+	}
+
+	var compressed bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, LevelOptions(9)); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, err := List(&compressed)
+	if err != nil || info.Blocks[0].ID != BLOCK_ID_SHAPE {
+		t.Errorf("Expected shape block, got %+v and error %v", info, err)
+	}
+}
+
+func TestDecodeContextInvalid(t *testing.T) {
+
+	context_block := func(length uint64, indexes []byte) *bytes.Buffer {
+		var buff bytes.Buffer
+		buff.WriteByte(BLOCK_ID_CONTEXT)
+		buff.Write(binary.LittleEndian.AppendUint64(nil, length))
+		buff.Write(indexes)
+		return &buff
+	}
+
+	err := Decode(context_block(CONTEXT_COUNT-1, make([]byte, CONTEXT_COUNT-1)), &bytes.Buffer{})
+	if !errors.Is(err, ErrInvalidContext) {
+		t.Errorf("Expected '%s', got %v", ErrInvalidContext, err)
+	}
+
+	// the trees the indexes refer to are missing
+	indexes := make([]byte, CONTEXT_COUNT)
+	indexes['a'] = 1
+
+	err = Decode(context_block(CONTEXT_COUNT, indexes), &bytes.Buffer{})
+
+	var format_err *FormatError
+	if !errors.As(err, &format_err) || format_err.BlockID != BLOCK_ID_SHAPE ||
+		format_err.Offset != 9+CONTEXT_COUNT || format_err.Err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected truncated shape block, got %v", err)
+	}
+}
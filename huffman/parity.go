@@ -0,0 +1,365 @@
+package huffman
+
+import (
+	"bytes"
+	"dense/fec"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Maximum percentage of damaged bytes per frame which parity can be added for
+const MAX_PARITY = 40
+
+const (
+	// Number of codewords interleaved in a frame, so a run of damaged bytes is spread over all of them
+	PARITY_INTERLEAVE = 64
+
+	// Length of the parameters of the parity block, which are stored three times
+	PARITY_PARAMS_LEN = 3
+	PARITY_LEN        = 3 * PARITY_PARAMS_LEN
+
+	// Flag of the payload length of the last frame of a stream
+	PARITY_FINAL = 1 << 31
+)
+
+var (
+	ErrInvalidParity = errors.New("Invalid parity block")
+	ErrUncorrectable = errors.New("Too many damaged bytes to correct")
+	ErrNoParity      = errors.New("Stream has no parity")
+)
+
+// Parameters of a stream with parity, stored in the parity block.
+// Everything following the parity block is stored in frames of interleave codewords of the code.
+type parity struct {
+	code       *fec.Code
+	interleave int
+}
+
+// Returns the parameters correcting up to percentage percent damaged bytes per frame
+func newParity(percentage int) (params *parity, err error) {
+
+	// a code corrects half as many bytes as it has parity bytes
+	parity_len := 2 * ((percentage*fec.MAX_CODEWORD_LEN + 99) / 100)
+
+	code, err := fec.NewCode(fec.MAX_CODEWORD_LEN-parity_len, parity_len)
+	if err != nil {
+		return
+	}
+
+	params = &parity{
+		code:       code,
+		interleave: PARITY_INTERLEAVE}
+	return
+}
+
+// Returns the number of bytes of a frame
+func (params *parity) frameSize() int {
+	return params.interleave * (params.code.DataLen() + params.code.ParityLen())
+}
+
+// Returns the number of payload bytes of a frame, which start with their length
+func (params *parity) payloadSize() int {
+	return params.interleave*params.code.DataLen() - 4
+}
+
+func (params *parity) encodeParity(writer io.Writer) (err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_PARITY, PARITY_LEN); err != nil {
+		return
+	}
+
+	body := []byte{byte(params.code.DataLen()), byte(params.code.ParityLen()), byte(params.interleave)}
+	for i := 0; i < 3; i++ {
+		if _, err = writer.Write(body); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func decodeParity(reader io.Reader) (params *parity, err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_PARITY)
+	if err != nil {
+		return
+	}
+
+	if length != PARITY_LEN {
+		err = ErrInvalidParity
+		return
+	}
+
+	body := make([]byte, PARITY_LEN)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	return parseParity(body)
+}
+
+// Parses the parameters stored three times in body, taking the majority of every byte
+func parseParity(body []byte) (params *parity, err error) {
+
+	values := make([]byte, PARITY_PARAMS_LEN)
+	for i := range values {
+		a, b, c := body[i], body[i+PARITY_PARAMS_LEN], body[i+2*PARITY_PARAMS_LEN]
+		if a != b && b == c {
+			a = b
+		}
+		values[i] = a
+	}
+
+	// a payload must hold more than its length
+	code, err := fec.NewCode(int(values[0]), int(values[1]))
+	if err != nil || int(values[2])*code.DataLen() <= 4 {
+		return nil, ErrInvalidParity
+	}
+
+	params = &parity{
+		code:       code,
+		interleave: int(values[2])}
+	return
+}
+
+// Codes a payload of payloadSize bytes into a frame, interleaving the codewords byte by byte
+func (params *parity) encodeFrame(payload []byte, frame []byte) {
+
+	data_len := params.code.DataLen()
+	codeword_len := data_len + params.code.ParityLen()
+	codeword := make([]byte, codeword_len)
+
+	for i := 0; i < params.interleave; i++ {
+		copy(codeword, payload[i*data_len:(i+1)*data_len])
+		params.code.Encode(codeword[:data_len], codeword[data_len:])
+
+		for j, b := range codeword {
+			frame[j*params.interleave+i] = b
+		}
+	}
+}
+
+// Corrects a frame in place and extracts its payload. Returns the number of corrected bytes.
+func (params *parity) decodeFrame(frame []byte, payload []byte) (corrected int, err error) {
+
+	data_len := params.code.DataLen()
+	codeword := make([]byte, data_len+params.code.ParityLen())
+
+	for i := 0; i < params.interleave; i++ {
+		for j := range codeword {
+			codeword[j] = frame[j*params.interleave+i]
+		}
+
+		codeword_corrected, err := params.code.Decode(codeword)
+		if err != nil {
+			return corrected, ErrUncorrectable
+		}
+		corrected += codeword_corrected
+
+		for j, b := range codeword {
+			frame[j*params.interleave+i] = b
+		}
+		copy(payload[i*data_len:], codeword[:data_len])
+	}
+	return
+}
+
+// Returns the length of the payload data following its length, and whether the frame is the last one
+func (params *parity) payloadLength(payload []byte) (length int, final bool, err error) {
+
+	value := binary.LittleEndian.Uint32(payload)
+	final = value&PARITY_FINAL != 0
+	length = int(value &^ PARITY_FINAL)
+
+	if length > params.payloadSize() {
+		err = ErrInvalidParity
+	}
+	return
+}
+
+// Writer storing everything written to it in frames with parity
+type parityWriter struct {
+	writer  io.Writer
+	params  *parity
+	payload []byte
+	length  int
+	frame   []byte
+}
+
+func newParityWriter(writer io.Writer, params *parity) *parityWriter {
+	return &parityWriter{
+		writer:  writer,
+		params:  params,
+		payload: make([]byte, 4+params.payloadSize()),
+		frame:   make([]byte, params.frameSize())}
+}
+
+func (parity_writer *parityWriter) Write(buff []byte) (n int, err error) {
+	for len(buff) > 0 {
+		space := copy(parity_writer.payload[4+parity_writer.length:], buff)
+		parity_writer.length += space
+		buff = buff[space:]
+		n += space
+
+		if parity_writer.length == parity_writer.params.payloadSize() {
+			if err = parity_writer.writeFrame(false); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Writes the buffered bytes, if any, in a frame which is not full
+func (parity_writer *parityWriter) Flush() error {
+	if parity_writer.length == 0 {
+		return nil
+	}
+	return parity_writer.writeFrame(false)
+}
+
+// Writes the last frame
+func (parity_writer *parityWriter) Close() error {
+	return parity_writer.writeFrame(true)
+}
+
+func (parity_writer *parityWriter) writeFrame(final bool) (err error) {
+
+	length := uint32(parity_writer.length)
+	if final {
+		length |= PARITY_FINAL
+	}
+
+	binary.LittleEndian.PutUint32(parity_writer.payload, length)
+	clear(parity_writer.payload[4+parity_writer.length:])
+	parity_writer.length = 0
+
+	parity_writer.params.encodeFrame(parity_writer.payload, parity_writer.frame)
+	_, err = parity_writer.writer.Write(parity_writer.frame)
+	return
+}
+
+// Reader correcting frames with parity, until the last one
+type parityReader struct {
+	reader    io.Reader
+	params    *parity
+	frame     []byte
+	payload   []byte
+	buff      []byte
+	final     bool
+	corrected int64
+}
+
+func newParityReader(reader io.Reader, params *parity) *parityReader {
+	return &parityReader{
+		reader:  reader,
+		params:  params,
+		frame:   make([]byte, params.frameSize()),
+		payload: make([]byte, 4+params.payloadSize())}
+}
+
+func (parity_reader *parityReader) Read(buff []byte) (n int, err error) {
+	for len(parity_reader.buff) == 0 {
+		if parity_reader.final {
+			return 0, io.EOF
+		}
+
+		if err = parity_reader.readFrame(); err != nil {
+			return
+		}
+	}
+
+	n = copy(buff, parity_reader.buff)
+	parity_reader.buff = parity_reader.buff[n:]
+	return
+}
+
+// Reads until the last frame and fails if any data is left
+func (parity_reader *parityReader) end() (err error) {
+	for !parity_reader.final {
+		if err = parity_reader.readFrame(); err != nil {
+			return
+		}
+	}
+
+	if len(parity_reader.buff) != 0 {
+		err = ErrUnexpectedBlock
+	}
+	return
+}
+
+func (parity_reader *parityReader) readFrame() (err error) {
+
+	if _, err = io.ReadFull(parity_reader.reader, parity_reader.frame); err != nil {
+		// the stream ends before its last frame
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	corrected, err := parity_reader.params.decodeFrame(parity_reader.frame, parity_reader.payload)
+	parity_reader.corrected += int64(corrected)
+	if err != nil {
+		return
+	}
+
+	length, final, err := parity_reader.params.payloadLength(parity_reader.payload)
+	if err != nil {
+		return
+	}
+
+	parity_reader.buff = parity_reader.payload[4 : 4+length]
+	parity_reader.final = final
+	return
+}
+
+// Copies a stream with parity, correcting its damaged bytes, and returns the number of corrected bytes.
+// A damaged parity block is rewritten as well. Every member of the stream must have parity.
+func Repair(reader io.Reader, writer io.Writer) (corrected int64, err error) {
+
+	for members := 0; ; members++ {
+		start := make([]byte, 9+PARITY_LEN)
+		read_bytes, read_err := io.ReadFull(reader, start)
+
+		if read_bytes == 0 && members > 0 {
+			return
+		}
+
+		if read_err != nil {
+			return corrected, ErrNoParity
+		}
+
+		// Either the block ID or the length of a damaged parity block is still intact
+		header := make([]byte, 9)
+		header[0] = BLOCK_ID_PARITY
+		binary.LittleEndian.PutUint64(header[1:], PARITY_LEN)
+		intact := start[0] == header[0] || bytes.Equal(start[1:9], header[1:])
+
+		params, parse_err := parseParity(start[9:])
+		if !intact || parse_err != nil {
+			return corrected, ErrNoParity
+		}
+
+		if err = params.encodeParity(writer); err != nil {
+			return
+		}
+
+		parity_reader := newParityReader(reader, params)
+		for !parity_reader.final {
+			err = parity_reader.readFrame()
+			corrected = parity_reader.corrected
+
+			if err != nil {
+				return
+			}
+
+			if _, err = writer.Write(parity_reader.frame); err != nil {
+				return
+			}
+		}
+	}
+}
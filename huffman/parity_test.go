@@ -0,0 +1,165 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func parityTestInput() []byte {
+	input := make([]byte, 200000)
+	for i := range input {
+		input[i] = byte(rand.Intn(16))
+	}
+	return input
+}
+
+// Damages count bytes starting at offset, returning a copy of compressed
+func damage(compressed []byte, offset, count int) []byte {
+	damaged := bytes.Clone(compressed)
+	for i := offset; i < offset+count; i++ {
+		damaged[i] ^= 0xA5
+	}
+	return damaged
+}
+
+func TestParity(t *testing.T) {
+
+	input := parityTestInput()
+	key := bytes.Repeat([]byte{0x42}, KEY_SIZE)
+
+	for _, encrypted := range []bool{false, true} {
+		options := LevelOptions(MAX_LEVEL)
+		options.Parity = 10
+		options.Header = &Header{Name: "file"}
+		decode_options := DecodeOptions{}
+
+		if encrypted {
+			options.Key = key
+			decode_options.Key = key
+		}
+
+		compressed := encrypt(t, input, options)
+
+		params, _ := newParity(options.Parity)
+		if (len(compressed)-9-PARITY_LEN)%params.frameSize() != 0 {
+			t.Errorf("Expected whole frames, got %d bytes", len(compressed))
+		}
+
+		// a burst in the first frame and a few scattered bytes in the last one
+		damaged := damage(compressed, 9+PARITY_LEN+100, 1000)
+		for i := 0; i < 10; i++ {
+			damaged[len(damaged)-1-100*i] ^= 0xFF
+		}
+
+		// one of the copies of the parity parameters
+		damaged[9+PARITY_LEN-1] ^= 0xFF
+
+		output, err := decrypt(damaged, decode_options)
+		if err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+		if !bytes.Equal(input, output) {
+			t.Errorf("Decompressed output differs from input")
+		}
+
+		if !encrypted {
+			info, err := List(bytes.NewReader(damaged))
+			if err != nil || !info.HasParity || info.Corrected != 1010 || info.Header == nil {
+				t.Errorf("Expected 1010 corrected bytes, got %+v and error %v", info, err)
+			}
+		}
+
+		var repaired bytes.Buffer
+		corrected, err := Repair(bytes.NewReader(damaged), &repaired)
+		if err != nil || corrected != 1010 {
+			t.Errorf("Expected 1010 corrected bytes, got %d and error %v", corrected, err)
+		}
+		if !bytes.Equal(compressed, repaired.Bytes()) {
+			t.Errorf("Repaired stream differs from original")
+		}
+	}
+}
+
+func TestParityFlush(t *testing.T) {
+
+	input := parityTestInput()
+
+	var compressed bytes.Buffer
+	options := DefaultOptions()
+	options.Parity = 5
+
+	compressor, _ := NewWriterOptions(&compressed, options)
+	compressor.Write(input[:1000])
+	if err := compressor.Flush(); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	params, _ := newParity(options.Parity)
+	if compressed.Len() != 9+PARITY_LEN+params.frameSize() {
+		t.Errorf("Expected parity block and one frame, got %d bytes", compressed.Len())
+	}
+
+	compressor.Write(input[1000:])
+	if err := compressor.Close(); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// concatenated members with parity
+	stream := append(bytes.Clone(compressed.Bytes()), compressed.Bytes()...)
+
+	output, err := decrypt(stream, DecodeOptions{})
+	if err != nil || !bytes.Equal(append(bytes.Clone(input), input...), output) {
+		t.Errorf("Decompressed output differs from input, got error %v", err)
+	}
+
+	var repaired bytes.Buffer
+	if _, err = Repair(bytes.NewReader(stream), &repaired); err != nil || !bytes.Equal(stream, repaired.Bytes()) {
+		t.Errorf("Repaired stream differs from original, got error %v", err)
+	}
+}
+
+func TestParityUncorrectable(t *testing.T) {
+
+	options := DefaultOptions()
+	options.Parity = 5
+	compressed := encrypt(t, parityTestInput(), options)
+
+	params, _ := newParity(options.Parity)
+	damaged := damage(compressed, 9+PARITY_LEN, params.frameSize()/4)
+
+	if _, err := decrypt(damaged, DecodeOptions{}); !errors.Is(err, ErrUncorrectable) {
+		t.Errorf("Expected '%s', got %v", ErrUncorrectable, err)
+	}
+
+	if _, err := Repair(bytes.NewReader(damaged), &bytes.Buffer{}); !errors.Is(err, ErrUncorrectable) {
+		t.Errorf("Expected '%s', got %v", ErrUncorrectable, err)
+	}
+
+	// the stream ends before its last frame
+	if _, err := decrypt(compressed[:len(compressed)-1], DecodeOptions{}); err == nil {
+		t.Errorf("Expected error for truncated stream")
+	}
+
+	compressed = encrypt(t, []byte("no parity"), DefaultOptions())
+	if _, err := Repair(bytes.NewReader(compressed), &bytes.Buffer{}); err != ErrNoParity {
+		t.Errorf("Expected '%s', got %v", ErrNoParity, err)
+	}
+}
+
+func TestParityTooSmall(t *testing.T) {
+
+	// a parity block whose payloads cannot hold their length, followed by a frame
+	compressed := []byte{BLOCK_ID_PARITY, PARITY_LEN, 0, 0, 0, 0, 0, 0, 0}
+	compressed = append(compressed, bytes.Repeat([]byte{1}, PARITY_LEN)...)
+	compressed = append(compressed, 0, 0, 0, 0)
+
+	if _, err := decrypt(compressed, DecodeOptions{}); !errors.Is(err, ErrInvalidParity) {
+		t.Errorf("Expected '%s', got %v", ErrInvalidParity, err)
+	}
+
+	if _, err := Repair(bytes.NewReader(compressed), &bytes.Buffer{}); err != ErrNoParity {
+		t.Errorf("Expected '%s', got %v", ErrNoParity, err)
+	}
+}
@@ -0,0 +1,72 @@
+package huffman
+
+import (
+	"context"
+	"io"
+)
+
+// Decompresses a stream while it is read
+type Reader struct {
+	// Header of the stream, empty if it has none
+	Header
+
+	pipe *io.PipeReader
+	done chan struct{}
+}
+
+// Creates a Reader, reading the header of the stream
+func NewReader(reader io.Reader) (*Reader, error) {
+	return NewReaderOptions(reader, DecodeOptions{})
+}
+
+// Creates a Reader with options, reading the header of the stream
+func NewReaderOptions(reader io.Reader, options DecodeOptions) (decompressor *Reader, err error) {
+
+	pipe_reader, pipe_writer := io.Pipe()
+
+	decompressor = &Reader{
+		pipe: pipe_reader,
+		done: make(chan struct{})}
+
+	decoder := newDecoder(context.Background(), reader, pipe_writer, options, nil)
+
+	// The header is known once the first block is peeked, or decoding fails before
+	headers := make(chan *Header, 1)
+	errs := make(chan error, 1)
+	decoder.header_func = func(header *Header) {
+		headers <- header
+	}
+
+	go func() {
+		defer close(decompressor.done)
+
+		err := decoder.decode()
+		if decoder.header_func != nil {
+			errs <- err
+		}
+		pipe_writer.CloseWithError(err)
+	}()
+
+	select {
+	case header := <-headers:
+		if header != nil {
+			decompressor.Header = *header
+		}
+	case err = <-errs:
+		if err != nil {
+			decompressor = nil
+		}
+	}
+	return
+}
+
+func (decompressor *Reader) Read(buff []byte) (n int, err error) {
+	return decompressor.pipe.Read(buff)
+}
+
+// Stops decompressing and waits until the underlying reader is no longer read
+func (decompressor *Reader) Close() error {
+	decompressor.pipe.Close()
+	<-decompressor.done
+	return nil
+}
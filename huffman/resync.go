@@ -0,0 +1,259 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Start of the body of every resync block, which recovery searches for after a damaged block
+const RESYNC_MAGIC = "\xd5DRESYNC"
+
+// Magic, offset, size, checksum and final flag of a block, followed by the CRC-32 of all of them
+const RESYNC_LEN = len(RESYNC_MAGIC) + 8 + 8 + 4 + 1 + 4
+
+var ErrInvalidResync = errors.New("Invalid resync block")
+
+// Marker preceding every block of a recoverable stream, with the offset, size and CRC-32 of its uncompressed data.
+// The final marker precedes the trailer.
+type resyncMarker struct {
+	offset int64
+	size   int64
+	crc    uint32
+	final  bool
+}
+
+func (marker *resyncMarker) encodeResync(writer io.Writer) (err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_RESYNC, uint64(RESYNC_LEN)); err != nil {
+		return
+	}
+
+	body := []byte(RESYNC_MAGIC)
+	body = binary.LittleEndian.AppendUint64(body, uint64(marker.offset))
+	body = binary.LittleEndian.AppendUint64(body, uint64(marker.size))
+	body = binary.LittleEndian.AppendUint32(body, marker.crc)
+	if marker.final {
+		body = append(body, 1)
+	} else {
+		body = append(body, 0)
+	}
+	body = binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+
+	_, err = writer.Write(body)
+	return
+}
+
+func decodeResync(reader io.Reader) (marker *resyncMarker, err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_RESYNC)
+	if err != nil {
+		return
+	}
+
+	if length != uint64(RESYNC_LEN) {
+		err = ErrInvalidResync
+		return
+	}
+
+	body := make([]byte, RESYNC_LEN)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	return parseResync(body)
+}
+
+func parseResync(body []byte) (marker *resyncMarker, err error) {
+
+	fields := body[len(RESYNC_MAGIC) : RESYNC_LEN-4]
+	if !bytes.Equal(body[:len(RESYNC_MAGIC)], []byte(RESYNC_MAGIC)) ||
+		crc32.ChecksumIEEE(body[:RESYNC_LEN-4]) != binary.LittleEndian.Uint32(body[RESYNC_LEN-4:]) ||
+		fields[20] > 1 {
+		err = ErrInvalidResync
+		return
+	}
+
+	marker = &resyncMarker{
+		offset: int64(binary.LittleEndian.Uint64(fields)),
+		size:   int64(binary.LittleEndian.Uint64(fields[8:])),
+		crc:    binary.LittleEndian.Uint32(fields[16:]),
+		final:  fields[20] == 1}
+
+	if marker.offset < 0 || marker.size < 0 || (marker.final && marker.size != 0) {
+		err = ErrInvalidResync
+	}
+	return
+}
+
+// Skips input until the body of a valid resync block and decodes it. Returns io.EOF if the input ends first.
+func (reader *blockReader) scanResync() (marker *resyncMarker, err error) {
+
+	body := make([]byte, RESYNC_LEN)
+	chunk := make([]byte, 4096)
+	window := []byte{}
+
+	for {
+		// The window keeps a suffix which may be the start of the magic
+		if index := bytes.Index(window, []byte(RESYNC_MAGIC)); index >= 0 {
+			reader.unread(window[index:])
+
+			if _, err = io.ReadFull(reader, body); err != nil {
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				return
+			}
+
+			if marker, err = parseResync(body); err == nil {
+				return
+			}
+
+			reader.unread(body[1:])
+			window = window[:0]
+			continue
+		}
+
+		window = window[max(0, len(window)-len(RESYNC_MAGIC)+1):]
+
+		n, read_err := reader.Read(chunk)
+		window = append(window, chunk[:n]...)
+
+		if read_err != nil && n == 0 {
+			if read_err == io.ErrUnexpectedEOF {
+				read_err = io.EOF
+			}
+			return nil, read_err
+		}
+	}
+}
+
+// Puts bytes back in front of the input
+func (reader *blockReader) unread(buff []byte) {
+	reader.peeked = append(bytes.Clone(buff), reader.peeked...)
+}
+
+// Buffer of the output of a block until it is verified, which fails once the block exceeds its size
+type blockBuffer struct {
+	bytes.Buffer
+	size int64
+}
+
+func (buff *blockBuffer) Write(data []byte) (n int, err error) {
+	if int64(buff.Len()+len(data)) > buff.size {
+		return 0, ErrInvalidBody
+	}
+	return buff.Buffer.Write(data)
+}
+
+// Decodes a resync block. When recovering, the output of the block following it is buffered
+// until it is verified. Otherwise only the offset is checked against the output so far.
+func (decoder *decoder) decodeResyncBlock() (err error) {
+
+	offset := decoder.reader.offset()
+	marker, err := decodeResync(decoder.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return newFormatError(err, BLOCK_ID_RESYNC, offset)
+	}
+	decoder.info.addBlock(BLOCK_ID_RESYNC, offset, decoder.reader.offset(), 0)
+
+	if decoder.recover == nil {
+		if marker.offset != decoder.writer.count-decoder.member_output {
+			return newFormatError(ErrInvalidResync, BLOCK_ID_RESYNC, offset)
+		}
+		return
+	}
+
+	return decoder.startResync(marker)
+}
+
+// Whether decoding can go on at the next resync block after err
+func (decoder *decoder) canRecover(err error) bool {
+	var format_err *FormatError
+	return decoder.recover != nil && decoder.resync_seen && errors.As(err, &format_err)
+}
+
+// Skips damaged input until the next resync block, or the end of the input which io.EOF is returned for
+func (decoder *decoder) skipDamaged() (err error) {
+
+	marker, err := decoder.reader.scanResync()
+	if err != nil {
+		if err == io.EOF {
+			decoder.endResync()
+		}
+		return
+	}
+
+	return decoder.startResync(marker)
+}
+
+// Writes the output of the previous block if it is intact, reports any data lost before the marker
+// and starts buffering the output of the block following it
+func (decoder *decoder) startResync(marker *resyncMarker) (err error) {
+
+	if err = decoder.finishResync(); err != nil {
+		return
+	}
+	decoder.resync_seen = true
+	decoder.resync_final = marker.final
+
+	if marker.offset > decoder.recovered {
+		decoder.reportLost(decoder.recovered, marker.offset-decoder.recovered)
+		decoder.recovered = marker.offset
+	}
+
+	if !marker.final {
+		decoder.resync = marker
+		decoder.resync_buff = &blockBuffer{size: marker.size}
+		decoder.output = decoder.resync_buff
+	}
+	return
+}
+
+// Writes the buffered output of the current block if its size and checksum match its marker
+func (decoder *decoder) finishResync() (err error) {
+
+	marker := decoder.resync
+	if marker == nil {
+		return
+	}
+
+	buff := decoder.resync_buff
+	decoder.resync = nil
+	decoder.resync_buff = nil
+	decoder.output = decoder.verified_output
+
+	if marker.offset == decoder.recovered && int64(buff.Len()) == marker.size &&
+		crc32.ChecksumIEEE(buff.Bytes()) == marker.crc {
+		_, err = decoder.output.Write(buff.Bytes())
+		decoder.recovered += marker.size
+	}
+	return
+}
+
+// Finishes the current block at the end of the input. Unless the final marker was read,
+// the rest of the member is lost.
+func (decoder *decoder) endResync() {
+
+	if decoder.finishResync() != nil || decoder.resync_final {
+		return
+	}
+	decoder.reportLost(decoder.recovered, -1)
+}
+
+func (decoder *decoder) reportLost(offset, size int64) {
+	decoder.member_lost = true
+	decoder.recover(decoder.member_start+offset, size)
+
+	if size > 0 {
+		decoder.lost_size += size
+	}
+}
@@ -0,0 +1,174 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+type lostRange struct {
+	offset int64
+	size   int64
+}
+
+func recoverableTestStream(t *testing.T) (input []byte, compressed []byte, info *StreamInfo) {
+
+	input = make([]byte, 50000)
+	for i := range input {
+		input[i] = byte('a' + rand.Intn(16))
+	}
+
+	options := DefaultOptions()
+	options.BlockSize = 10000
+	options.Recoverable = true
+	compressed = encrypt(t, input, options)
+
+	info, err := List(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+	return
+}
+
+// Decodes with recovery, returning the output and the ranges that were lost
+func recoverStream(compressed []byte) (output []byte, lost []lostRange, err error) {
+
+	var buff bytes.Buffer
+	options := DecodeOptions{
+		Recover: func(offset, size int64) {
+			lost = append(lost, lostRange{offset, size})
+		}}
+
+	err = DecodeContext(context.Background(), bytes.NewReader(compressed), &buff, options)
+	return buff.Bytes(), lost, err
+}
+
+// Returns the blocks of the stream with the given ID
+func blocksWithID(info *StreamInfo, id byte) (blocks []BlockInfo) {
+	for _, block := range info.Blocks {
+		if block.ID == id {
+			blocks = append(blocks, block)
+		}
+	}
+	return
+}
+
+func TestRecoverable(t *testing.T) {
+
+	input, compressed, info := recoverableTestStream(t)
+
+	// a resync block precedes every block and the trailer
+	if resync_count := len(blocksWithID(info, BLOCK_ID_RESYNC)); resync_count != 6 || info.Blocks[0].ID != BLOCK_ID_RESYNC {
+		t.Errorf("Expected 6 resync blocks, got %d", resync_count)
+	}
+
+	output, lost, err := recoverStream(compressed)
+	if err != nil || len(lost) != 0 || !bytes.Equal(input, output) {
+		t.Errorf("Expected intact output, got %v lost and error %v", lost, err)
+	}
+
+	// damage in the second data block
+	data_blocks := blocksWithID(info, BLOCK_ID_DATA)
+	damaged := bytes.Clone(compressed)
+	damaged[data_blocks[1].Offset+data_blocks[1].Length/2] ^= 0xFF
+
+	if err = Decode(bytes.NewReader(damaged), &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected error for damaged stream")
+	}
+
+	output, lost, err = recoverStream(damaged)
+	if err != nil || len(lost) != 1 || lost[0] != (lostRange{10000, 10000}) {
+		t.Fatalf("Expected bytes 10000 to 20000 lost, got %v and error %v", lost, err)
+	}
+
+	if !bytes.Equal(output, append(bytes.Clone(input[:10000]), input[20000:]...)) {
+		t.Errorf("Expected all other blocks to be recovered")
+	}
+
+	// damage in the resync block of the fourth block and the shape block of the fifth
+	resync_blocks := blocksWithID(info, BLOCK_ID_RESYNC)
+	shape_blocks := blocksWithID(info, BLOCK_ID_SHAPE)
+	damaged = bytes.Clone(compressed)
+	damaged[resync_blocks[3].Offset+12] ^= 0xFF
+	damaged[shape_blocks[4].Offset+1] ^= 0xFF
+
+	output, lost, err = recoverStream(damaged)
+	if err != nil || len(lost) != 2 || lost[0] != (lostRange{30000, 10000}) || lost[1] != (lostRange{40000, 10000}) {
+		t.Fatalf("Expected bytes 30000 to 50000 lost, got %v and error %v", lost, err)
+	}
+
+	if !bytes.Equal(output, input[:30000]) {
+		t.Errorf("Expected the first blocks to be recovered")
+	}
+}
+
+func TestRecoverTruncated(t *testing.T) {
+
+	input, compressed, info := recoverableTestStream(t)
+	data_blocks := blocksWithID(info, BLOCK_ID_DATA)
+
+	output, lost, err := recoverStream(compressed[:data_blocks[2].Offset+10])
+	if err != nil || len(lost) != 1 || lost[0] != (lostRange{20000, -1}) {
+		t.Errorf("Expected bytes from 20000 lost, got %v and error %v", lost, err)
+	}
+
+	if !bytes.Equal(output, input[:20000]) {
+		t.Errorf("Expected the first blocks to be recovered")
+	}
+
+	// only the trailer is missing
+	output, lost, err = recoverStream(compressed[:info.Blocks[len(info.Blocks)-1].Offset])
+	if err != nil || len(lost) != 0 || !bytes.Equal(input, output) {
+		t.Errorf("Expected intact output, got %v lost and error %v", lost, err)
+	}
+}
+
+func TestRecoverWithoutResync(t *testing.T) {
+
+	input := bytes.Repeat([]byte("no resync blocks "), 1000)
+	compressed := encrypt(t, input, DefaultOptions())
+	compressed[20] ^= 0xFF
+
+	// streams without resync blocks cannot be recovered
+	_, lost, err := recoverStream(compressed)
+	var format_err *FormatError
+	if !errors.As(err, &format_err) && !errors.Is(err, ErrChecksum) || len(lost) != 0 {
+		t.Errorf("Expected error, got %v lost and error %v", lost, err)
+	}
+}
+
+func TestRecoverableDirect(t *testing.T) {
+
+	input, _, _ := recoverableTestStream(t)
+
+	// blocks larger than MaxBuffer are read twice, and once more for their checksum
+	options := DefaultOptions()
+	options.BlockSize = 10000
+	options.MaxBuffer = 1000
+	options.Recoverable = true
+
+	output, lost, err := recoverStream(encrypt(t, input, options))
+	if err != nil || len(lost) != 0 || !bytes.Equal(input, output) {
+		t.Errorf("Expected intact output, got %v lost and error %v", lost, err)
+	}
+
+	var compressed bytes.Buffer
+	compressor, _ := NewWriterOptions(&compressed, options)
+	compressor.Write(input[:5000])
+	compressor.Flush()
+	compressor.Write(input[5000:])
+	if err = compressor.Close(); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if err = Decode(bytes.NewReader(compressed.Bytes()), &bytes.Buffer{}); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	output, lost, err = recoverStream(compressed.Bytes())
+	if err != nil || len(lost) != 0 || !bytes.Equal(input, output) {
+		t.Errorf("Expected intact output, got %v lost and error %v", lost, err)
+	}
+}
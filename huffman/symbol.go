@@ -0,0 +1,156 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Types of symbols a tree can encode.
+// Besides bytes, 16-bit symbols allow literal/length alphabets and strings allow word-based coding.
+type Symbol interface {
+	byte | uint16 | string
+}
+
+// Maximum number of distinct symbols in a tree over 16-bit symbols or strings
+const MAX_SYMBOL_LEAVES = 1 << 16
+
+var ErrTooManySymbols = errors.New("Too many distinct symbols")
+
+// Returns the maximum number of leaves of a tree over S
+func maxLeaves[S Symbol]() int {
+	var symbol S
+	if _, ok := any(symbol).(byte); ok {
+		return MAX_LEAVES
+	}
+	return MAX_SYMBOL_LEAVES
+}
+
+// Returns the number of bytes a symbol takes in the leaves block, zero if it varies
+func symbolSize[S Symbol]() int {
+	var symbol S
+	switch any(symbol).(type) {
+	case byte:
+		return 1
+	case uint16:
+		return 2
+	}
+	return 0
+}
+
+// Serializes a symbol for the leaves block.
+// Bytes are written as is, 16-bit symbols in little endian and strings prefixed by their length as uvarint.
+func writeSymbol[S Symbol](buff *bytes.Buffer, symbol S) {
+	switch value := any(symbol).(type) {
+	case byte:
+		buff.WriteByte(value)
+	case uint16:
+		buff.Write(binary.LittleEndian.AppendUint16(nil, value))
+	case string:
+		buff.Write(binary.AppendUvarint(nil, uint64(len(value))))
+		buff.WriteString(value)
+	}
+}
+
+// Reads a symbol written by writeSymbol
+func readSymbol[S Symbol](buff *bytes.Buffer) (symbol S, err error) {
+
+	var value any
+
+	switch any(symbol).(type) {
+	case byte:
+		value, err = buff.ReadByte()
+	case uint16:
+		if buff.Len() < 2 {
+			return symbol, io.ErrUnexpectedEOF
+		}
+		value = binary.LittleEndian.Uint16(buff.Next(2))
+	case string:
+		var length uint64
+		if length, err = binary.ReadUvarint(buff); err != nil {
+			return
+		}
+		if length > uint64(buff.Len()) {
+			return symbol, io.ErrUnexpectedEOF
+		}
+		value = string(buff.Next(int(length)))
+	}
+
+	if err != nil {
+		return
+	}
+
+	symbol = value.(S)
+	return
+}
+
+// Compresses symbols with one tree, written as a shape, leaves and data block
+func EncodeSymbols[S Symbol](symbols []S, writer io.Writer) (err error) {
+
+	counts := make(map[S]int64)
+	for _, symbol := range symbols {
+		counts[symbol]++
+	}
+
+	if len(counts) > maxLeaves[S]() {
+		return ErrTooManySymbols
+	}
+
+	tree := generateTreeFromCounts(counts)
+
+	if err = tree.encodeTree(writer); err != nil {
+		return
+	}
+
+	err = tree.encodeBody(symbols, writer, tree.getEncodingTable())
+	return
+}
+
+// Decompresses symbols written by EncodeSymbols
+func DecodeSymbols[S Symbol](reader io.Reader) (symbols []S, err error) {
+
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	tree, err := decodeSymbolTreeShape[S](reader)
+	if err != nil {
+		return
+	}
+
+	if err = tree.decodeTreeLeaves(reader); err != nil {
+		return
+	}
+
+	err = tree.decodeBody(reader, func(symbol S) error {
+		symbols = append(symbols, symbol)
+		return nil
+	})
+	return
+}
+
+// Splits text into words and the bytes between them, for word-based coding with EncodeSymbols.
+// Words are runs of ASCII letters and digits and of non-ASCII bytes, which keeps UTF-8 sequences intact.
+// Joining the tokens gives back the text.
+func SplitWords(text []byte) (tokens []string) {
+
+	is_word_byte := func(b byte) bool {
+		return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+	}
+
+	for len(text) > 0 {
+		length := 1
+		if is_word_byte(text[0]) {
+			for length < len(text) && is_word_byte(text[length]) {
+				length++
+			}
+		}
+
+		tokens = append(tokens, string(text[:length]))
+		text = text[length:]
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeSymbols16(t *testing.T) {
+
+	// every 16-bit value, some more frequent than others
+	symbols := make([]uint16, 0, 1<<17)
+	for i := 0; i < 1<<16; i++ {
+		symbols = append(symbols, uint16(i))
+		if i%7 == 0 {
+			symbols = append(symbols, uint16(i), uint16(i))
+		}
+	}
+
+	var buff bytes.Buffer
+	if err := EncodeSymbols(symbols, &buff); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	output, err := DecodeSymbols[uint16](&buff)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if !reflect.DeepEqual(symbols, output) {
+		t.Errorf("Decoded symbols differ from input")
+	}
+}
+
+func TestEncodeDecodeWords(t *testing.T) {
+
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog, naïve café\n", 50))
+
+	tokens := SplitWords(text)
+	if strings.Join(tokens, "") != string(text) {
+		t.Errorf("Joined tokens differ from text")
+	}
+
+	expected_tokens := []string{"the", " ", "quick", " ", "brown"}
+	if !reflect.DeepEqual(tokens[:5], expected_tokens) {
+		t.Errorf("Expected %q, got %q", expected_tokens, tokens[:5])
+	}
+
+	if tokens[19] != "naïve" {
+		t.Errorf("Expected %q, got %q", "naïve", tokens[19])
+	}
+
+	var buff bytes.Buffer
+	if err := EncodeSymbols(tokens, &buff); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	output, err := DecodeSymbols[string](&buff)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if !reflect.DeepEqual(tokens, output) {
+		t.Errorf("Expected %q, got %q", tokens, output)
+	}
+
+	// truncated streams
+	buff.Reset()
+	EncodeSymbols(tokens, &buff)
+	for _, length := range []int{0, 5, buff.Len() - 1} {
+		if _, err = DecodeSymbols[string](bytes.NewReader(buff.Bytes()[:length])); err == nil {
+			t.Errorf("Expected error for %d bytes", length)
+		}
+	}
+}
+
+func TestEncodeSymbolsTooMany(t *testing.T) {
+
+	tokens := make([]string, MAX_SYMBOL_LEAVES+1)
+	for i := range tokens {
+		tokens[i] = fmt.Sprint(i)
+	}
+
+	if err := EncodeSymbols(tokens, &bytes.Buffer{}); err != ErrTooManySymbols {
+		t.Errorf("Expected '%s', got %v", ErrTooManySymbols, err)
+	}
+}
+
+func TestSymbolTreeLeaves(t *testing.T) {
+
+	tree := &SymbolTree[string]{
+		left:  &SymbolTree[string]{data: "dense"},
+		right: &SymbolTree[string]{data: ""}}
+
+	var buff bytes.Buffer
+	tree.encodeTreeLeaves(&buff)
+
+	expected_buff := []byte{BLOCK_ID_LEAVES, 7, 0, 0, 0, 0, 0, 0, 0, 5, 'd', 'e', 'n', 's', 'e', 0}
+	if !bytes.Equal(buff.Bytes(), expected_buff) {
+		t.Errorf("Expected %v, got %v", expected_buff, buff.Bytes())
+	}
+
+	decoded_tree := &SymbolTree[string]{
+		left:  &SymbolTree[string]{},
+		right: &SymbolTree[string]{}}
+
+	if err := decoded_tree.decodeTreeLeaves(&buff); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !reflect.DeepEqual(tree, decoded_tree) {
+		t.Errorf("Expected %v, got %v", tree, decoded_tree)
+	}
+
+	// string lengths which do not match the block length
+	for _, leaves := range [][]byte{{5, 'd', 'e', 'n', 's', 'e'}, {1, 'a', 0, 0}, {9, 'a'}} {
+		buff.Reset()
+		buff.WriteByte(BLOCK_ID_LEAVES)
+		buff.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(leaves))))
+		buff.Write(leaves)
+
+		if err := decoded_tree.decodeTreeLeaves(&buff); err != ErrInvalidLeaves {
+			t.Errorf("Expected '%s', got %v", ErrInvalidLeaves, err)
+		}
+	}
+}
+
+func TestSymbolTreeLeaves16(t *testing.T) {
+
+	tree := &SymbolTree[uint16]{
+		left:  &SymbolTree[uint16]{},
+		right: &SymbolTree[uint16]{}}
+
+	// two bytes per leaf
+	for _, leaves_len := range []uint64{2, 3, 5} {
+		var buff bytes.Buffer
+		buff.WriteByte(BLOCK_ID_LEAVES)
+		buff.Write(binary.LittleEndian.AppendUint64(nil, leaves_len))
+		buff.Write([]byte{0x01, 0x02, 0x03, 0x04})
+
+		if err := tree.decodeTreeLeaves(&buff); err != ErrInvalidLeaves {
+			t.Errorf("Expected '%s', got %v", ErrInvalidLeaves, err)
+		}
+	}
+
+	var buff bytes.Buffer
+	buff.WriteByte(BLOCK_ID_LEAVES)
+	buff.Write(binary.LittleEndian.AppendUint64(nil, 4))
+	buff.Write([]byte{0x01, 0x02, 0x03, 0x04})
+
+	if err := tree.decodeTreeLeaves(&buff); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if tree.left.data != 0x0201 || tree.right.data != 0x0403 {
+		t.Errorf("Expected leaves 0x0201 and 0x0403, got %#x and %#x", tree.left.data, tree.right.data)
+	}
+}
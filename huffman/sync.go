@@ -0,0 +1,48 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Body of every sync block, unlikely to occur in a stream otherwise
+const SYNC_MAGIC = "\xd5DSYNC\x00\xff"
+
+var ErrInvalidSync = errors.New("Invalid sync block")
+
+// Writes a sync block, which marks that everything before it can be decoded
+func encodeSync(writer io.Writer) (err error) {
+
+	if err = writeBlockHeader(writer, BLOCK_ID_SYNC, uint64(len(SYNC_MAGIC))); err != nil {
+		return
+	}
+
+	_, err = io.WriteString(writer, SYNC_MAGIC)
+	return
+}
+
+func decodeSync(reader io.Reader) (err error) {
+
+	length, err := readBlockHeader(reader, BLOCK_ID_SYNC)
+	if err != nil {
+		return
+	}
+
+	if length != uint64(len(SYNC_MAGIC)) {
+		return ErrInvalidSync
+	}
+
+	magic_buff := make([]byte, len(SYNC_MAGIC))
+	if _, err = io.ReadFull(reader, magic_buff); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	if !bytes.Equal(magic_buff, []byte(SYNC_MAGIC)) {
+		err = ErrInvalidSync
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWriterFlush(t *testing.T) {
+
+	var compressed bytes.Buffer
+	writer, _ := NewWriterLevel(&compressed, MAX_LEVEL)
+
+	// everything written before a flush can be decoded before the stream ends,
+	// the stream is only reported as truncated after that
+	expected := ""
+	for _, part := range []string{"hello hello", "hello", "", "something else"} {
+		writer.Write([]byte(part))
+		expected += part
+
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		var output bytes.Buffer
+		if err := Decode(bytes.NewReader(compressed.Bytes()), &output); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected '%s', got %v", io.ErrUnexpectedEOF, err)
+		}
+
+		if output.String() != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, output.String())
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	info, err := List(bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// the second part reuses the trees of the first one, the last part needs a tree of its own
+	expected_ids := []byte{BLOCK_ID_SHAPE, BLOCK_ID_LEAVES, BLOCK_ID_DATA, BLOCK_ID_SYNC, BLOCK_ID_DATA,
+		BLOCK_ID_SYNC, BLOCK_ID_SYNC, BLOCK_ID_SHAPE, BLOCK_ID_LEAVES, BLOCK_ID_DATA, BLOCK_ID_SYNC,
+		BLOCK_ID_TRAILER}
+
+	ids := []byte{}
+	for _, block := range info.Blocks {
+		ids = append(ids, block.ID)
+	}
+
+	if !bytes.Equal(ids, expected_ids) {
+		t.Errorf("Expected blocks %v, got %v", expected_ids, ids)
+	}
+
+	if err = writer.Flush(); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestDecodeSyncInvalid(t *testing.T) {
+
+	var compressed bytes.Buffer
+	writer := NewWriter(&compressed)
+	writer.Write([]byte("content"))
+	writer.Flush()
+	writer.Close()
+
+	info, _ := List(bytes.NewReader(compressed.Bytes()))
+	sync_offset := info.Blocks[3].Offset
+
+	corrupted := append([]byte{}, compressed.Bytes()...)
+	corrupted[sync_offset+9] ^= 0xFF
+
+	var output bytes.Buffer
+	err := Decode(bytes.NewReader(corrupted), &output)
+
+	var format_err *FormatError
+	if !errors.As(err, &format_err) || format_err.Err != ErrInvalidSync || format_err.Offset != sync_offset {
+		t.Errorf("Expected '%s' at offset %d, got %v", ErrInvalidSync, sync_offset, err)
+	}
+}
@@ -0,0 +1,171 @@
+package huffman
+
+import (
+	"bytes"
+	"dense/bits"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Start of every table file
+const TABLE_MAGIC = "DTBL"
+
+var (
+	ErrInvalidTable    = errors.New("Invalid table file")
+	ErrInvalidTableRef = errors.New("Invalid table reference")
+	ErrUnknownTable    = errors.New("Unknown table")
+)
+
+// Pre-computed Huffman tree, which streams can reference by ID instead of embedding a tree
+type Table struct {
+	id             uint32
+	tree           *HuffmanTree
+	encoding_table map[byte]bits.Slice
+}
+
+// Builds a table from a training sample.
+// Every byte value gets a code, so the table can encode any input.
+func TrainTable(reader io.Reader) (table *Table, err error) {
+
+	counts, err := countBytes(reader)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < MAX_LEAVES; i++ {
+		counts[byte(i)]++
+	}
+
+	table, err = newTable(generateTreeFromCounts(counts))
+	return
+}
+
+// Reads a table file as written by WriteTo
+func ReadTable(reader io.Reader) (table *Table, err error) {
+
+	tree, err := readTreeFile(reader, TABLE_MAGIC, ErrInvalidTable)
+	if err != nil {
+		return
+	}
+
+	table, err = newTable(tree)
+	return
+}
+
+// Reads the tree of a table or dictionary file starting with magic.
+// Returns invalid if the magic differs or the codes of the tree are too long to encode with.
+func readTreeFile(reader io.Reader, magic string, invalid error) (tree *HuffmanTree, err error) {
+
+	magic_buff := make([]byte, len(magic))
+	if _, err = io.ReadFull(reader, magic_buff); err != nil || string(magic_buff) != magic {
+		err = invalid
+		return
+	}
+
+	if tree, err = decodeTreeShape(reader); err != nil {
+		return
+	}
+
+	if err = tree.decodeTreeLeaves(reader); err != nil {
+		return
+	}
+
+	if tree.depth() > MAX_CODE_LEN {
+		err = invalid
+	}
+	return
+}
+
+// Creates a table, its ID is the CRC-32 of the serialized tree
+func newTable(tree *HuffmanTree) (table *Table, err error) {
+
+	var tree_buff bytes.Buffer
+	if err = tree.encodeTree(&tree_buff); err != nil {
+		return
+	}
+
+	table = &Table{
+		id:             crc32.ChecksumIEEE(tree_buff.Bytes()),
+		tree:           tree,
+		encoding_table: tree.getEncodingTable()}
+	return
+}
+
+// Returns the ID by which streams reference the table
+func (table *Table) ID() uint32 {
+	return table.id
+}
+
+// Writes the table file
+func (table *Table) WriteTo(writer io.Writer) (n int64, err error) {
+
+	var table_buff bytes.Buffer
+	table_buff.WriteString(TABLE_MAGIC)
+
+	if err = table.tree.encodeTree(&table_buff); err != nil {
+		return
+	}
+
+	return table_buff.WriteTo(writer)
+}
+
+// Writes the table block, which replaces the shape and leaves blocks
+func (table *Table) encodeTableRef(writer io.Writer) (err error) {
+	return writeIDBlock(writer, BLOCK_ID_TABLE, table.id)
+}
+
+func decodeTableRef(reader io.Reader) (id uint32, err error) {
+	id, err = readIDBlock(reader, BLOCK_ID_TABLE)
+	if err == errInvalidIDBlock {
+		err = ErrInvalidTableRef
+	}
+	return
+}
+
+var errInvalidIDBlock = errors.New("Invalid ID block")
+
+// Writes a block which only contains an ID
+func writeIDBlock(writer io.Writer, block_id byte, id uint32) (err error) {
+
+	if err = writeBlockHeader(writer, block_id, 4); err != nil {
+		return
+	}
+
+	id_buff := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id_buff, id)
+
+	_, err = writer.Write(id_buff)
+	return
+}
+
+func readIDBlock(reader io.Reader, block_id byte) (id uint32, err error) {
+
+	length, err := readBlockHeader(reader, block_id)
+	if err != nil {
+		return
+	}
+
+	if length != 4 {
+		err = errInvalidIDBlock
+		return
+	}
+
+	id_buff := make([]byte, 4)
+	if _, err = io.ReadFull(reader, id_buff); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+
+	id = binary.LittleEndian.Uint32(id_buff)
+	return
+}
+
+// Returns the error for a stream referencing a table that was not passed to the decoder
+func unknownTableError(id uint32) error {
+	return fmt.Errorf("%w %08x", ErrUnknownTable, id)
+}
@@ -0,0 +1,196 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestTrainTable(t *testing.T) {
+
+	table, err := TrainTable(bytes.NewBufferString("aaaaaaaabbbbc"))
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// every byte value can be encoded
+	if len(table.encoding_table) != MAX_LEAVES {
+		t.Errorf("Expected %d codes, got %d", MAX_LEAVES, len(table.encoding_table))
+	}
+
+	code_a := table.encoding_table['a']
+	code_z := table.encoding_table['z']
+	if code_a.Len() >= code_z.Len() {
+		t.Errorf("Expected shorter code for 'a', got %d and %d", code_a.Len(), code_z.Len())
+	}
+}
+
+func TestTableWriteRead(t *testing.T) {
+
+	table, _ := TrainTable(bytes.NewBufferString("this is some content"))
+
+	var buff bytes.Buffer
+	if _, err := table.WriteTo(&buff); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	read_table, err := ReadTable(&buff)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	if read_table.ID() != table.ID() {
+		t.Errorf("Expected ID %08x, got %08x", table.ID(), read_table.ID())
+	}
+
+	if _, err = ReadTable(bytes.NewBufferString("not a table")); err != ErrInvalidTable {
+		t.Errorf("Expected '%s', got %v", ErrInvalidTable, err)
+	}
+}
+
+// Returns a tree with codes of up to leaf_count-1 bits, longer than any code a bits.Slice holds
+func chainTree(leaf_count int) *HuffmanTree {
+	tree := &HuffmanTree{data: 0}
+	for i := 1; i < leaf_count; i++ {
+		tree = &HuffmanTree{
+			left:  &HuffmanTree{data: byte(i)},
+			right: tree}
+	}
+	return tree
+}
+
+func TestReadTableTooDeep(t *testing.T) {
+
+	// a chain of leaf_count leaves has codes of up to leaf_count-1 bits
+	for _, leaf_count := range []int{MAX_CODE_LEN + 2, 100} {
+		var buff bytes.Buffer
+		buff.WriteString(TABLE_MAGIC)
+		chainTree(leaf_count).encodeTree(&buff)
+
+		if _, err := ReadTable(&buff); err != ErrInvalidTable {
+			t.Errorf("%d leaves: expected '%s', got %v", leaf_count, ErrInvalidTable, err)
+		}
+	}
+}
+
+func TestEncodeDeepTable(t *testing.T) {
+
+	var buff bytes.Buffer
+	buff.WriteString(TABLE_MAGIC)
+	chainTree(MAX_CODE_LEN + 1).encodeTree(&buff)
+
+	table, err := ReadTable(&buff)
+	if err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	// mostly short codes, so the table is referenced, and a few of the longest codes
+	var input []byte
+	for i, count := range []int{1000, 500, 1, 1} {
+		input = append(input, bytes.Repeat([]byte{byte(MAX_CODE_LEN - i)}, count)...)
+	}
+	input = append(input, 0)
+
+	options := DefaultOptions()
+	options.Table = table
+
+	var compressed, output bytes.Buffer
+	if err = EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	decode_options := DecodeOptions{Tables: []*Table{table}}
+	info, err := ListWithOptions(bytes.NewReader(compressed.Bytes()), decode_options)
+	if err != nil || info.Blocks[0].ID != BLOCK_ID_TABLE {
+		t.Fatalf("Expected table block, got %+v and error %v", info, err)
+	}
+
+	if err = DecodeContext(context.Background(), &compressed, &output, decode_options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Errorf("Decompressed output differs from input")
+	}
+}
+
+func TestEncodeDecodeTable(t *testing.T) {
+
+	sample := bytes.Repeat([]byte(`{"id": 1234, "name": "dense", "tags": ["a", "b"]}`), 100)
+	table, _ := TrainTable(bytes.NewReader(sample))
+
+	message := []byte(`{"id": 42, "name": "huffman", "tags": []}`)
+
+	options := DefaultOptions()
+	options.Table = table
+
+	var with_table, without_table bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(message), &with_table, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+	if err := Encode(bytes.NewReader(message), &without_table); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if with_table.Len() >= without_table.Len() {
+		t.Errorf("Expected table to help, got %d and %d bytes", with_table.Len(), without_table.Len())
+	}
+
+	info, err := ListWithOptions(bytes.NewReader(with_table.Bytes()), DecodeOptions{Tables: []*Table{table}})
+	if err != nil || info.Blocks[0].ID != BLOCK_ID_TABLE {
+		t.Errorf("Expected table block, got %+v and error %v", info, err)
+	}
+
+	// decoding requires the table
+	var output bytes.Buffer
+	err = Decode(bytes.NewReader(with_table.Bytes()), &output)
+	if !errors.Is(err, ErrUnknownTable) {
+		t.Errorf("Expected '%s', got %v", ErrUnknownTable, err)
+	}
+
+	output.Reset()
+	err = DecodeContext(context.Background(), bytes.NewReader(with_table.Bytes()), &output,
+		DecodeOptions{Tables: []*Table{table}})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !bytes.Equal(message, output.Bytes()) {
+		t.Errorf("Expected '%s', got '%s'", message, output.Bytes())
+	}
+}
+
+func TestEncodeTableNotUsed(t *testing.T) {
+
+	// a table trained on other data is not used when embedding a tree is smaller
+	table, _ := TrainTable(bytes.NewBufferString("aaaaaaaaaaaaaaaa"))
+
+	input := make([]byte, 100000)
+	for i := range input {
+		input[i] = byte(rand.Intn(256))
+	}
+
+	options := DefaultOptions()
+	options.Table = table
+
+	var compressed, output bytes.Buffer
+	if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, err := List(bytes.NewReader(compressed.Bytes()))
+	if err != nil || info.Blocks[0].ID != BLOCK_ID_SHAPE {
+		t.Errorf("Expected shape block, got %+v and error %v", info, err)
+	}
+
+	err = DecodeContext(context.Background(), &compressed, &output, DecodeOptions{Tables: []*Table{table}})
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if !bytes.Equal(input, output.Bytes()) {
+		t.Errorf("Decompressed output differs from input")
+	}
+}
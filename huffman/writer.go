@@ -0,0 +1,547 @@
+package huffman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Compresses everything written to it, the stream is completed by Close
+type Writer struct {
+	ctx           context.Context
+	writer        *countingWriter
+	progress      ProgressFunc
+	block_size    int64
+	concurrency   int
+	checksum_type byte
+	checksum      hash.Hash
+	table         *Table
+	order         int
+	dictionary    *Dictionary
+	header        *Header
+	encryption    *encryption
+	sealer        *sealWriter
+	parity        *parity
+	parity_writer *parityWriter
+	resync        bool
+	resync_offset int64
+	size          uint64
+	max_buffer    int64
+	block_buff    []byte
+	block_length  int64
+	spill         *os.File
+	tables        *byteTables
+	block_count   int
+	pending       []chan encodedBlock
+	err           error
+	closed        bool
+}
+
+type encodedBlock struct {
+	buff   bytes.Buffer
+	tables *byteTables
+	size   int64
+	crc    uint32
+	err    error
+}
+
+// Creates a new Writer with default options
+func NewWriter(writer io.Writer) *Writer {
+	compressor, _ := NewWriterOptions(writer, DefaultOptions())
+	return compressor
+}
+
+// Creates a new Writer using a compression level, with otherwise default options
+func NewWriterLevel(writer io.Writer, level int) (*Writer, error) {
+	return NewWriterOptions(writer, LevelOptions(level))
+}
+
+// Creates a new Writer
+func NewWriterOptions(writer io.Writer, options Options) (compressor *Writer, err error) {
+
+	block_size, err := options.validate()
+	if err != nil {
+		return
+	}
+
+	checksum, err := newChecksum(options.checksumType())
+	if err != nil {
+		return
+	}
+
+	table := options.Table
+	if options.Dictionary != nil {
+		table = options.Dictionary.table
+	}
+
+	concurrency := options.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var params *encryption
+	var sealer *sealWriter
+	var parity_params *parity
+	var parity_writer *parityWriter
+
+	// Everything following the parity block is stored in frames with parity
+	if options.Parity > 0 {
+		if parity_params, err = newParity(options.Parity); err != nil {
+			return
+		}
+		parity_writer = newParityWriter(writer, parity_params)
+	}
+
+	if options.Key != nil || options.Passphrase != "" {
+		if params, err = newEncryption(options.Key, options.Passphrase); err != nil {
+			return
+		}
+
+		sealer = &sealWriter{writer: writer}
+		if parity_writer != nil {
+			sealer.writer = parity_writer
+		}
+		if sealer.aead, err = params.newAEAD(options.Key, options.Passphrase); err != nil {
+			return
+		}
+
+		if sealer.prefix, err = encryptionPrefix(options.Header, params); err != nil {
+			return
+		}
+	}
+
+	compressor = &Writer{
+		ctx:           context.Background(),
+		writer:        &countingWriter{writer: writer},
+		progress:      options.Progress,
+		block_size:    block_size,
+		concurrency:   concurrency,
+		checksum_type: options.checksumType(),
+		checksum:      checksum,
+		table:         table,
+		order:         options.contextOrder(),
+		dictionary:    options.Dictionary,
+		header:        options.Header,
+		encryption:    params,
+		sealer:        sealer,
+		parity:        parity_params,
+		parity_writer: parity_writer,
+		resync:        options.Recoverable,
+		max_buffer:    options.maxBuffer()}
+	return
+}
+
+// Compresses with custom options
+func EncodeWithOptions(reader io.Reader, writer io.Writer, options Options) (err error) {
+	return EncodeContext(context.Background(), reader, writer, options)
+}
+
+// Buffers data and encodes every block that is full
+func (compressor *Writer) Write(buff []byte) (n int, err error) {
+
+	if compressor.closed {
+		return 0, errors.New("Write to closed Writer")
+	}
+
+	if compressor.err != nil {
+		return 0, compressor.err
+	}
+
+	compressor.checksum.Write(buff)
+	compressor.size += uint64(len(buff))
+	n = len(buff)
+
+	defer compressor.reportProgress()
+
+	for len(buff) > 0 && compressor.err == nil {
+		space := int64(len(buff))
+		if compressor.block_size != 0 {
+			space = min(space, compressor.block_size-compressor.block_length)
+		}
+
+		compressor.buffer(buff[:space])
+		buff = buff[space:]
+
+		if compressor.block_length == compressor.block_size {
+			compressor.flushBlock()
+		}
+	}
+
+	err = compressor.err
+	return
+}
+
+// Adds data to the block, which is spilled to a temporary file once it exceeds max_buffer
+func (compressor *Writer) buffer(data []byte) {
+
+	if compressor.spill == nil && compressor.block_length+int64(len(data)) > compressor.max_buffer {
+		if compressor.spill, compressor.err = os.CreateTemp("", "dense-"); compressor.err != nil {
+			return
+		}
+
+		if _, compressor.err = compressor.spill.Write(compressor.block_buff); compressor.err != nil {
+			return
+		}
+		compressor.block_buff = nil
+	}
+
+	if compressor.spill != nil {
+		_, compressor.err = compressor.spill.Write(data)
+	} else {
+		compressor.block_buff = append(compressor.block_buff, data...)
+	}
+	compressor.block_length += int64(len(data))
+}
+
+// Encodes the buffered data and writes a sync block, so everything written so far can be decoded.
+// The stream is not ended, the data is coded with the trees of the previous block where that is smaller.
+// If the underlying writer has a Flush method, it is called as well.
+func (compressor *Writer) Flush() (err error) {
+
+	if compressor.closed {
+		return errors.New("Flush of closed Writer")
+	}
+
+	if compressor.err != nil {
+		return compressor.err
+	}
+
+	if compressor.spill != nil {
+		compressor.flushSpill()
+	} else if compressor.block_length > 0 {
+		data := compressor.block_buff
+		compressor.block_buff = nil
+		compressor.block_length = 0
+
+		compressor.encodeDirect(func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		})
+	}
+
+	for len(compressor.pending) > 0 {
+		compressor.writePending()
+	}
+
+	if compressor.err != nil {
+		return compressor.err
+	}
+
+	if compressor.err = compressor.writeStart(); compressor.err != nil {
+		return compressor.err
+	}
+
+	if compressor.err = encodeSync(compressor.writer); compressor.err != nil {
+		return compressor.err
+	}
+	output := compressor.writer.writer
+	if compressor.sealer != nil {
+		if compressor.err = compressor.sealer.Flush(); compressor.err != nil {
+			return compressor.err
+		}
+		output = compressor.sealer.writer
+	}
+	if compressor.parity_writer != nil {
+		if compressor.err = compressor.parity_writer.Flush(); compressor.err != nil {
+			return compressor.err
+		}
+		output = compressor.parity_writer.writer
+	}
+	compressor.reportProgress()
+
+	if flusher, ok := output.(interface{ Flush() error }); ok {
+		compressor.err = flusher.Flush()
+	}
+	return compressor.err
+}
+
+// Encodes the last block and writes the trailer. It does not close the underlying writer.
+func (compressor *Writer) Close() (err error) {
+
+	if compressor.closed {
+		return compressor.err
+	}
+	compressor.closed = true
+
+	// An empty input is still encoded as one empty block
+	if compressor.block_length > 0 || compressor.block_count == 0 {
+		compressor.flushBlock()
+	}
+
+	for len(compressor.pending) > 0 {
+		compressor.writePending()
+	}
+
+	if compressor.err != nil {
+		return compressor.err
+	}
+
+	if compressor.resync {
+		if compressor.err = compressor.writeResync(0, 0, true); compressor.err != nil {
+			return compressor.err
+		}
+	}
+
+	compressor.err = encodeTrailer(compressor.writer, compressor.checksum_type,
+		compressor.size, checksumDigest(compressor.checksum))
+
+	if compressor.err == nil && compressor.sealer != nil {
+		compressor.err = compressor.sealer.Close()
+	}
+
+	if compressor.err == nil && compressor.parity_writer != nil {
+		compressor.err = compressor.parity_writer.Close()
+	}
+	compressor.reportProgress()
+	return compressor.err
+}
+
+// Starts encoding the buffered block, waiting for earlier blocks if too many are in progress
+func (compressor *Writer) flushBlock() {
+
+	if compressor.err == nil {
+		compressor.err = compressor.ctx.Err()
+	}
+
+	if compressor.spill != nil {
+		compressor.flushSpill()
+		return
+	}
+
+	data := compressor.block_buff
+	compressor.block_buff = nil
+	compressor.block_length = 0
+	compressor.block_count++
+
+	table := compressor.table
+	order := compressor.order
+	resync := compressor.resync
+	result := make(chan encodedBlock, 1)
+	compressor.pending = append(compressor.pending, result)
+
+	go func() {
+		var block encodedBlock
+		open := func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		}
+
+		_, block.tables, block.err = encodeBlockFrom(open, &block.buff, table, order, nil)
+		if resync {
+			block.size = int64(len(data))
+			block.crc = crc32.ChecksumIEEE(data)
+		}
+		result <- block
+	}()
+
+	for len(compressor.pending) >= compressor.concurrency {
+		compressor.writePending()
+	}
+}
+
+// Encodes the block spilled to a temporary file and removes the file
+func (compressor *Writer) flushSpill() {
+
+	spill := compressor.spill
+	compressor.block_length = 0
+	defer compressor.removeSpill()
+
+	open := func() (io.Reader, error) {
+		_, err := spill.Seek(0, io.SeekStart)
+		return spill, err
+	}
+
+	compressor.encodeDirect(open)
+}
+
+// Removes the temporary file of a spilled block, if any
+func (compressor *Writer) removeSpill() {
+	if compressor.spill != nil {
+		compressor.spill.Close()
+		os.Remove(compressor.spill.Name())
+		compressor.spill = nil
+	}
+}
+
+// Encodes a block which is not kept in memory, after all blocks in progress.
+// The block is read through open twice and its encoding is written directly.
+// It may reuse the trees of the previous block. Returns the number of bytes encoded.
+func (compressor *Writer) encodeDirect(open func() (io.Reader, error)) (size int64) {
+
+	for len(compressor.pending) > 0 {
+		compressor.writePending()
+	}
+
+	if compressor.err != nil {
+		return
+	}
+	compressor.block_count++
+
+	if compressor.err = compressor.writeStart(); compressor.err != nil {
+		return
+	}
+
+	// The resync block needs the size and checksum of the block, which is then read once more
+	previous := compressor.tables
+	if compressor.resync {
+		previous = nil
+
+		var reader io.Reader
+		if reader, compressor.err = open(); compressor.err != nil {
+			return
+		}
+
+		crc := crc32.NewIEEE()
+		if size, compressor.err = io.Copy(crc, reader); compressor.err != nil {
+			return
+		}
+
+		if compressor.err = compressor.writeResync(size, crc.Sum32(), false); compressor.err != nil {
+			return
+		}
+	}
+
+	size, compressor.tables, compressor.err = encodeBlockFrom(open, compressor.writer, compressor.table,
+		compressor.order, previous)
+	compressor.reportProgress()
+	return
+}
+
+// Encodes an input which can seek, reading every block twice instead of keeping it in memory
+func (compressor *Writer) encodeSeekable(reader io.ReadSeeker, start int64) {
+
+	checksum_pass := 2
+	if compressor.resync {
+		checksum_pass = 3
+	}
+
+	for compressor.err == nil {
+		offset := start
+		passes := 0
+
+		open := func() (block io.Reader, err error) {
+			if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+				return
+			}
+
+			block = &contextReader{ctx: compressor.ctx, reader: reader}
+			if compressor.block_size != 0 {
+				block = io.LimitReader(block, compressor.block_size)
+			}
+
+			// the checksum covers the bytes that are encoded, in the last pass
+			passes++
+			if passes == checksum_pass {
+				block = io.TeeReader(block, compressor.checksum)
+			}
+			return
+		}
+
+		// Stop at the end of the input, unless it is empty
+		if compressor.block_count > 0 {
+			block, err := open()
+			if err != nil {
+				compressor.err = err
+				return
+			}
+
+			if _, err = io.ReadFull(block, make([]byte, 1)); err == io.EOF {
+				return
+			}
+			passes = 0
+		}
+
+		size := compressor.encodeDirect(open)
+		compressor.size += uint64(size)
+		start += size
+
+		if compressor.block_size == 0 || size < compressor.block_size {
+			return
+		}
+	}
+}
+
+// Waits for the oldest block in progress and writes it
+func (compressor *Writer) writePending() {
+
+	block := <-compressor.pending[0]
+	compressor.pending = compressor.pending[1:]
+
+	if compressor.err != nil {
+		return
+	}
+
+	if block.err != nil {
+		compressor.err = block.err
+		return
+	}
+
+	if compressor.err = compressor.writeStart(); compressor.err != nil {
+		return
+	}
+
+	if compressor.resync {
+		if compressor.err = compressor.writeResync(block.size, block.crc, false); compressor.err != nil {
+			return
+		}
+	}
+
+	compressor.tables = block.tables
+	_, compressor.err = compressor.writer.Write(block.buff.Bytes())
+	compressor.reportProgress()
+}
+
+// Writes the header and dictionary blocks, which precede the first block
+func (compressor *Writer) writeStart() (err error) {
+	if compressor.writer.count != 0 {
+		return
+	}
+
+	// Everything following the parity block is stored in frames
+	if compressor.parity != nil {
+		if err = compressor.parity.encodeParity(compressor.writer); err != nil {
+			return
+		}
+		compressor.writer.writer = compressor.parity_writer
+	}
+
+	if compressor.header != nil {
+		if err = compressor.header.encodeHeader(compressor.writer); err != nil {
+			return
+		}
+	}
+
+	// Everything following the encryption block is sealed
+	if compressor.encryption != nil {
+		if err = compressor.encryption.encodeEncryption(compressor.writer); err != nil {
+			return
+		}
+		compressor.writer.writer = compressor.sealer
+	}
+
+	if compressor.dictionary != nil {
+		err = compressor.dictionary.encodeDictionaryRef(compressor.writer)
+	}
+	return
+}
+
+// Writes a resync block preceding a block of size bytes, or the trailer if final
+func (compressor *Writer) writeResync(size int64, crc uint32, final bool) (err error) {
+
+	marker := &resyncMarker{
+		offset: compressor.resync_offset,
+		size:   size,
+		crc:    crc,
+		final:  final}
+
+	compressor.resync_offset += size
+	return marker.encodeResync(compressor.writer)
+}
+
+func (compressor *Writer) reportProgress() {
+	if compressor.progress != nil {
+		compressor.progress(int64(compressor.size), compressor.writer.count)
+	}
+}
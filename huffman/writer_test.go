@@ -0,0 +1,203 @@
+package huffman
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterDefaultOutput(t *testing.T) {
+
+	// The default options should produce a single block, like Encode always did
+	var output bytes.Buffer
+	if err := Encode(bytes.NewBufferString("this is some content"), &output); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	info, err := List(&output)
+	if err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	expected_ids := []byte{BLOCK_ID_SHAPE, BLOCK_ID_LEAVES, BLOCK_ID_DATA, BLOCK_ID_TRAILER}
+
+	if len(info.Blocks) != len(expected_ids) {
+		t.Fatalf("Expected %d blocks, got %v", len(expected_ids), info.Blocks)
+	}
+
+	for i, block := range info.Blocks {
+		if block.ID != expected_ids[i] {
+			t.Errorf("Unexpected block %+v at index %d", block, i)
+		}
+	}
+
+	if info.ChecksumType != CHECKSUM_CRC32 {
+		t.Errorf("Expected checksum type %d, got %d", CHECKSUM_CRC32, info.ChecksumType)
+	}
+}
+
+func TestWriterBlockSize(t *testing.T) {
+
+	input := make([]byte, 10000)
+	for i := range input {
+		input[i] = byte(rand.Intn(16))
+	}
+
+	for _, block_size := range []int64{1, 100, 4096, 10000, 20000} {
+		for _, concurrency := range []int{0, 1, 4} {
+
+			options := DefaultOptions()
+			options.BlockSize = block_size
+			options.Concurrency = concurrency
+
+			var compressed, decompressed bytes.Buffer
+			if err := EncodeWithOptions(bytes.NewReader(input), &compressed, options); err != nil {
+				t.Errorf("Got error %s", err)
+			}
+
+			info, err := List(bytes.NewReader(compressed.Bytes()))
+			if err != nil {
+				t.Errorf("Got error %s", err)
+			}
+
+			expected_blocks := 3*((int64(len(input))+block_size-1)/block_size) + 1
+			if int64(len(info.Blocks)) != expected_blocks {
+				t.Errorf("Block size %d: expected %d blocks, got %d", block_size,
+					expected_blocks, len(info.Blocks))
+			}
+
+			if err = Decode(&compressed, &decompressed); err != nil {
+				t.Errorf("Got error %s", err)
+			}
+
+			if !bytes.Equal(input, decompressed.Bytes()) {
+				t.Errorf("Block size %d: decompressed output differs from input", block_size)
+			}
+		}
+	}
+}
+
+func TestWriterLevels(t *testing.T) {
+
+	input := bytes.Repeat([]byte("this is some content "), 50000)
+
+	for level := MIN_LEVEL; level <= MAX_LEVEL; level++ {
+
+		var compressed, decompressed bytes.Buffer
+
+		writer, err := NewWriterLevel(&compressed, level)
+		if err != nil {
+			t.Fatalf("Got error %s", err)
+		}
+
+		// many small writes
+		for i := 0; i < len(input); i += 1000 {
+			if _, err = writer.Write(input[i : i+1000]); err != nil {
+				t.Errorf("Got error %s", err)
+			}
+		}
+
+		if err = writer.Close(); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if err = Decode(&compressed, &decompressed); err != nil {
+			t.Errorf("Got error %s", err)
+		}
+
+		if !bytes.Equal(input, decompressed.Bytes()) {
+			t.Errorf("Level %d: decompressed output differs from input", level)
+		}
+	}
+
+	var buff bytes.Buffer
+	for _, level := range []int{-1, MAX_LEVEL + 1} {
+		if _, err := NewWriterLevel(&buff, level); err == nil {
+			t.Errorf("Level %d: expected error, got nil", level)
+		}
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+
+	var buff bytes.Buffer
+	writer := NewWriter(&buff)
+
+	if err := writer.Close(); err != nil {
+		t.Errorf("Got error %s", err)
+	}
+
+	if _, err := writer.Write([]byte{0x1}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestWriterMaxBuffer(t *testing.T) {
+
+	input := make([]byte, 10000)
+	for i := range input {
+		input[i] = byte(rand.Intn(16))
+	}
+
+	file_name := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(file_name, input, 0644); err != nil {
+		t.Fatalf("Got error %s", err)
+	}
+
+	for _, level := range []int{1, DEFAULT_LEVEL, MAX_LEVEL} {
+		for _, block_size := range []int64{0, 1000, 4096, 5000} {
+
+			options := LevelOptions(level)
+			options.BlockSize = block_size
+
+			var expected bytes.Buffer
+			if err := EncodeWithOptions(bytes.NewReader(input), &expected, options); err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+
+			expected_info, _ := List(&expected)
+
+			// blocks above the maximum buffer are read twice from the file or spilled
+			options.MaxBuffer = 1024
+
+			file, err := os.Open(file_name)
+			if err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+
+			var seeked, spilled bytes.Buffer
+			err = EncodeWithOptions(file, &seeked, options)
+			file.Close()
+
+			if err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+
+			if err = EncodeWithOptions(io.MultiReader(bytes.NewReader(input)), &spilled, options); err != nil {
+				t.Fatalf("Got error %s", err)
+			}
+
+			for _, compressed := range []*bytes.Buffer{&seeked, &spilled} {
+				info, err := List(bytes.NewReader(compressed.Bytes()))
+				// blocks which are not kept in memory may reuse the trees of the previous block
+				if err != nil || info.CompressedSize > expected_info.CompressedSize ||
+					info.UncompressedSize != expected_info.UncompressedSize || info.Checksum != expected_info.Checksum {
+					t.Errorf("Expected %+v for level %d and block size %d, got %+v and error %v",
+						expected_info, level, block_size, info, err)
+				}
+
+				var decompressed bytes.Buffer
+				if err = Decode(compressed, &decompressed); err != nil {
+					t.Errorf("Got error %s", err)
+				}
+
+				if !bytes.Equal(decompressed.Bytes(), input) {
+					t.Errorf("Decompressed output differs for level %d and block size %d", level, block_size)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/lk16/dense/huffman"
+	"io"
+	"os"
+)
+
+// Tree as printed by 'dense inspect --tree --format json'
+type inspectedTree struct {
+	Index  int                      `json:"index"`
+	Weight int64                    `json:"weight"`
+	Leaves []huffman.TreeLeaf[byte] `json:"leaves"`
+}
+
+// Prints the trees of a compressed file, or the tree compressing a raw file would use.
+// Files which are not dense streams are treated as raw input.
+func runInspect(args []string) int {
+
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flag_tree := flags.Bool("tree", false, "Print the Huffman trees.")
+	flag_format := flags.String("format", "dot", "Output format, dot or json.")
+	flag_raw := flags.Bool("raw", false, "Treat the input as raw data, even if it is a dense stream.")
+	flag_table := flags.String("table", "", "Table file the stream may reference.")
+	flag_dictionary := flags.String("dict", "", "Dictionary file the stream was compressed with.")
+	flags.Parse(args)
+
+	if !*flag_tree {
+		return fail(EXIT_USAGE, "Nothing to inspect, use --tree.")
+	}
+
+	if *flag_format != "dot" && *flag_format != "json" {
+		return fail(EXIT_USAGE, "Unknown format '%s'.", *flag_format)
+	}
+
+	if flags.NArg() > 1 {
+		return fail(EXIT_USAGE, "Expected at most one input file.")
+	}
+
+	decode_options := huffman.DecodeOptions{}
+
+	if *flag_table != "" {
+		table, err := readTable(*flag_table)
+		if err != nil {
+			return fail(EXIT_USAGE, "Could not read table '%s': %s", *flag_table, err)
+		}
+		decode_options.Tables = []*huffman.Table{table}
+	}
+
+	if *flag_dictionary != "" {
+		dictionary, err := readDictionary(*flag_dictionary)
+		if err != nil {
+			return fail(EXIT_USAGE, "Could not read dictionary '%s': %s", *flag_dictionary, err)
+		}
+		decode_options.Dictionary = dictionary
+	}
+
+	input_name := flags.Arg(0)
+	input_file := os.Stdin
+
+	if input_name != "" {
+		var err error
+		if input_file, err = os.Open(input_name); err != nil {
+			return fail(EXIT_IO, "%s", err)
+		}
+		defer input_file.Close()
+	}
+
+	// The input is read twice if it turns out not to be a dense stream
+	data, err := io.ReadAll(input_file)
+	if err != nil {
+		return fail(EXIT_IO, "%s: %s", displayName(input_name), err)
+	}
+
+	var trees []*huffman.HuffmanTree
+	if !*flag_raw && len(data) > 0 {
+		trees, err = huffman.ReadTrees(bytes.NewReader(data), decode_options)
+	}
+
+	// Data which fails to decode at its first block is not a dense stream
+	var format_err *huffman.FormatError
+	not_dense := errors.As(err, &format_err) && format_err.Offset == 0
+
+	if *flag_raw || len(data) == 0 || not_dense {
+		var tree *huffman.HuffmanTree
+		tree, err = huffman.BuildTree(bytes.NewReader(data))
+		trees = []*huffman.HuffmanTree{tree}
+	}
+
+	if err != nil {
+		return fail(exitCode(err), "%s: %s", displayName(input_name), err)
+	}
+
+	if *flag_format == "json" {
+		inspected_trees := make([]inspectedTree, len(trees))
+		for i, tree := range trees {
+			inspected_trees[i] = inspectedTree{
+				Index:  i,
+				Weight: tree.Weight(),
+				Leaves: tree.Leaves()}
+		}
+
+		err = json.NewEncoder(os.Stdout).Encode(inspected_trees)
+	} else {
+		for i, tree := range trees {
+			if err = tree.WriteDOT(os.Stdout, fmt.Sprintf("tree%d", i)); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+	return EXIT_OK
+}
@@ -1,51 +1,629 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/lk16/dense/huffman"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SUFFIX = ".dense"
+
+const (
+	EXIT_OK        = 0
+	EXIT_USAGE     = 1
+	EXIT_IO        = 2
+	EXIT_FORMAT    = 3
+	EXIT_INTEGRITY = 4
+
+	// Conventional exit code of a process terminated by SIGINT
+	EXIT_INTERRUPTED = 130
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// Subcommands, as in 'dense train -o dict.bin samples/'
+var commands = map[string]func(args []string) int{
+	"train":   runTrain,
+	"inspect": runInspect,
+	"analyze": runAnalyze,
+	"bench":   runBench,
+	"repair":  runRepair}
+
+func run() int {
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			return command(os.Args[2:])
+		}
+	}
 
 	flag_input_file := flag.String("i", "", "Input file")
 	flag_output_file := flag.String("o", "", "Output file")
 	flag_decode := flag.Bool("d", false, "If used, specifies decompressing.")
+	flag_list := flag.Bool("l", false, "If used, lists the blocks of a compressed file.")
+	flag_test := flag.Bool("t", false, "If used, tests the integrity of a compressed file.")
+	flag_force := flag.Bool("f", false, "Overwrite existing output files.")
+	flag_keep := flag.Bool("k", false, "Keep the input file.")
+	flag_stdout := flag.Bool("c", false, "Write to standard output and keep the input file.")
+	flag_block_size := flag.String("block-size", "", "Number of input bytes per block, e.g. 64k or 1M.")
+	flag_table := flag.String("table", "", "Table file to compress with, or needed to decompress.")
+	flag_train_table := flag.String("train-table", "", "Trains a table on the input and writes it to this file.")
+	flag_dictionary := flag.String("dict", "", "Dictionary file to compress with, or needed to decompress.")
+	flag_key_file := flag.String("key-file", "", "File with a 32 byte key to encrypt or decrypt with.")
+	flag_passphrase_file := flag.String("passphrase-file", "", "File with a passphrase to encrypt or decrypt with.")
+	flag_recoverable := flag.Bool("recoverable", false, "Writes resync blocks, so damaged files can be decompressed with --recover.")
+	flag_recover := flag.Bool("recover", false, "Skips damaged blocks when decompressing and reports the data lost.")
+	flag_parity := flag.Int("parity", 0, "Adds parity to correct up to this percentage of damaged bytes.")
+
+	var flag_name bool
+	flag.BoolVar(&flag_name, "N", false, "Store or restore the file name, modification time and permissions.")
+	flag.BoolVar(&flag_name, "name", false, "Same as -N.")
+
+	flag_levels := make(map[int]*bool)
+	for level := huffman.MIN_LEVEL; level <= huffman.MAX_LEVEL; level++ {
+		flag_levels[level] = flag.Bool(strconv.Itoa(level), false, fmt.Sprintf("Compression level %d", level))
+	}
+
 	flag.Parse()
 
+	options := huffman.DefaultOptions()
+	decode_options := huffman.DecodeOptions{}
+
+	level_count := 0
+	for level, flag_level := range flag_levels {
+		if *flag_level {
+			options.Level = level
+			level_count++
+		}
+	}
+
+	if level_count > 1 {
+		return fail(EXIT_USAGE, "Expected at most one compression level.")
+	}
+
+	if *flag_parity < 0 || *flag_parity > huffman.MAX_PARITY {
+		return fail(EXIT_USAGE, "Expected a parity percentage from 0 to %d.", huffman.MAX_PARITY)
+	}
+	options.Parity = *flag_parity
+	options.Recoverable = *flag_recoverable
+
+	if *flag_recover && !*flag_decode {
+		return fail(EXIT_USAGE, "Flag --recover requires -d.")
+	}
+
+	// Lost ranges are reported once decompressing is done, so they do not interfere with the progress bar
+	var lost_ranges [][2]int64
+	if *flag_recover {
+		decode_options.Recover = func(offset, size int64) {
+			lost_ranges = append(lost_ranges, [2]int64{offset, size})
+		}
+	}
+
+	if *flag_block_size != "" {
+		var err error
+		if options.BlockSize, err = parseSize(*flag_block_size); err != nil {
+			return fail(EXIT_USAGE, "Invalid block size '%s'.", *flag_block_size)
+		}
+	}
+
+	input_name := *flag_input_file
+
+	// Allow passing the input file without -i, as in 'dense -l file.dense'
+	if flag.NArg() > 0 {
+		if input_name != "" || flag.NArg() > 1 {
+			return fail(EXIT_USAGE, "Expected at most one input file.")
+		}
+		input_name = flag.Arg(0)
+	}
+
+	if *flag_stdout && *flag_output_file != "" {
+		return fail(EXIT_USAGE, "Flags -c and -o cannot be combined.")
+	}
+
 	input_file := os.Stdin
-	output_file := os.Stdout
-	var err error
 
-	if *flag_input_file != "" {
-		input_file, err = os.Open(*flag_input_file)
+	if input_name != "" {
+		var err error
+		input_file, err = os.Open(input_name)
 		if err != nil {
-			panic(err)
+			return fail(EXIT_IO, "%s", err)
 		}
+		defer input_file.Close()
 	}
 
-	if *flag_output_file != "" {
-		if _, err := os.Stat(*flag_output_file); !os.IsNotExist(err) {
-			fmt.Printf("File '%s' exists already. Exiting.\n", *flag_output_file)
-			return
+	if *flag_train_table != "" {
+		return trainTable(input_file, *flag_train_table, *flag_force)
+	}
+
+	if *flag_table != "" {
+		table, err := readTable(*flag_table)
+		if err != nil {
+			return fail(EXIT_USAGE, "Could not read table '%s': %s", *flag_table, err)
 		}
+		options.Table = table
+		decode_options.Tables = []*huffman.Table{table}
+	}
 
-		output_file, err = os.Create(*flag_output_file)
+	if *flag_dictionary != "" {
+		dictionary, err := readDictionary(*flag_dictionary)
 		if err != nil {
-			fmt.Printf("Could not create file '%s': %s\n", *flag_output_file, err)
-			return
+			return fail(EXIT_USAGE, "Could not read dictionary '%s': %s", *flag_dictionary, err)
 		}
+		options.Dictionary = dictionary
+		decode_options.Dictionary = dictionary
+	}
+
+	if options.Table != nil && options.Dictionary != nil {
+		return fail(EXIT_USAGE, "Flags --table and --dict cannot be combined.")
 	}
 
+	if *flag_key_file != "" && *flag_passphrase_file != "" {
+		return fail(EXIT_USAGE, "Flags --key-file and --passphrase-file cannot be combined.")
+	}
+
+	if *flag_key_file != "" {
+		key, err := readKey(*flag_key_file)
+		if err != nil {
+			return fail(EXIT_USAGE, "Could not read key '%s': %s", *flag_key_file, err)
+		}
+		options.Key = key
+		decode_options.Key = key
+	}
+
+	if *flag_passphrase_file != "" {
+		passphrase, err := readPassphrase(*flag_passphrase_file)
+		if err != nil {
+			return fail(EXIT_USAGE, "Could not read passphrase '%s': %s", *flag_passphrase_file, err)
+		}
+		options.Passphrase = passphrase
+		decode_options.Passphrase = passphrase
+	}
+
+	if *flag_list {
+		if err := list(input_file, os.Stdout, decode_options); err != nil {
+			return fail(exitCode(err), "%s: %s", displayName(input_name), err)
+		}
+		return EXIT_OK
+	}
+
+	if *flag_test {
+		err := huffman.DecodeContext(context.Background(), input_file, io.Discard, decode_options)
+		if err != nil {
+			return fail(exitCode(err), "%s: FAILED: %s", displayName(input_name), err)
+		}
+		fmt.Printf("%s: OK\n", displayName(input_name))
+		return EXIT_OK
+	}
+
+	var header *huffman.Header
+
+	if flag_name && input_name != "" {
+		var err error
+		if *flag_decode {
+			header, err = readHeader(input_file, decode_options)
+		} else {
+			header, err = fileHeader(input_file)
+			options.Header = header
+		}
+
+		if err != nil {
+			return fail(exitCode(err), "%s: %s", displayName(input_name), err)
+		}
+	}
+
+	output_name := *flag_output_file
+	remove_input := false
+
+	// Restore the stored file name next to the input file
+	if *flag_decode && header != nil && output_name == "" && !*flag_stdout {
+		if name := filepath.Base(header.Name); header.Name != "" && name != "." && name != ".." && name != "/" {
+			output_name = filepath.Join(filepath.Dir(input_name), name)
+			remove_input = !*flag_keep
+
+			// The input would be replaced by the output and then removed
+			if sameFile(output_name, input_name) {
+				return fail(EXIT_USAGE, "Stored name '%s' is that of the input file. Use -o.", header.Name)
+			}
+		}
+	}
+
+	// Derive the output file name from the input file name, like gzip does
+	if output_name == "" && input_name != "" && !*flag_stdout {
+		var err error
+		if output_name, err = outputName(input_name, *flag_decode); err != nil {
+			return fail(EXIT_USAGE, "%s", err)
+		}
+		remove_input = !*flag_keep
+	}
+
+	var output_writer io.Writer = os.Stdout
+	var output_file *atomicFile
+
+	if output_name != "" {
+		if _, err := os.Stat(output_name); !os.IsNotExist(err) && !*flag_force {
+			return fail(EXIT_IO, "File '%s' exists already. Use -f to overwrite.", output_name)
+		}
+
+		var err error
+		output_file, err = createAtomic(output_name)
+		if err != nil {
+			return fail(EXIT_IO, "Could not create file '%s': %s", output_name, err)
+		}
+		defer output_file.Abort()
+		output_writer = output_file
+	} else if !*flag_decode && !*flag_force && isTerminal(os.Stdout) {
+		return fail(EXIT_USAGE, "Compressed data not written to a terminal. Use -f to force.")
+	}
+
+	// Show progress unless the terminal is used for output already
+	var bar *progressBar
+	if isTerminal(os.Stderr) && (output_file != nil || !isTerminal(os.Stdout)) {
+		total := int64(0)
+		if stat, err := input_file.Stat(); err == nil && stat.Mode().IsRegular() {
+			total = stat.Size()
+		}
+
+		bar = newProgressBar(os.Stderr, total)
+		options.Progress = bar.update
+	}
+
+	var err error
 	if *flag_decode {
-		err = huffman.Decode(input_file, output_file)
+		decode_options.Progress = options.Progress
+		err = huffman.DecodeContext(context.Background(), input_file, output_writer, decode_options)
 	} else {
-		err = huffman.Encode(input_file, output_file)
+		err = huffman.EncodeWithOptions(input_file, output_writer, options)
+	}
+
+	if bar != nil {
+		bar.finish()
+	}
+
+	if err != nil {
+		return fail(exitCode(err), "%s: %s", displayName(input_name), err)
+	}
+
+	for _, lost := range lost_ranges {
+		if lost[1] < 0 {
+			fmt.Fprintf(os.Stderr, "dense: %s: lost bytes from %d to the end\n", displayName(input_name), lost[0])
+		} else {
+			fmt.Fprintf(os.Stderr, "dense: %s: lost bytes %d to %d\n", displayName(input_name), lost[0], lost[0]+lost[1])
+		}
+	}
+
+	if output_file != nil {
+		var source os.FileInfo
+		if input_name != "" {
+			source, _ = input_file.Stat()
+		}
+
+		if *flag_decode && header != nil && source != nil {
+			source = headerInfo{FileInfo: source, header: header}
+		}
+
+		if err = output_file.Commit(source); err != nil {
+			return fail(EXIT_IO, "Could not write file '%s': %s", output_name, err)
+		}
+	}
+
+	// The damaged input is kept, it may be repaired otherwise
+	if len(lost_ranges) > 0 {
+		return EXIT_INTEGRITY
+	}
+
+	if remove_input {
+		input_file.Close()
+		if err = os.Remove(input_name); err != nil {
+			return fail(EXIT_IO, "Could not remove file '%s': %s", input_name, err)
+		}
+	}
+
+	return EXIT_OK
+}
+
+// Prints an error message to stderr and returns the exit code
+func fail(code int, format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "dense: "+format+"\n", args...)
+	return code
+}
+
+// Maps an error of Encode or Decode to an exit code
+func exitCode(err error) int {
+	if errors.Is(err, huffman.ErrChecksum) || errors.Is(err, huffman.ErrAuthentication) ||
+		errors.Is(err, huffman.ErrUncorrectable) {
+		return EXIT_INTEGRITY
+	}
+
+	if errors.Is(err, huffman.ErrUnknownTable) || errors.Is(err, huffman.ErrUnknownDictionary) ||
+		errors.Is(err, huffman.ErrKeyRequired) || errors.Is(err, huffman.ErrNotEncrypted) {
+		return EXIT_USAGE
 	}
 
+	var format_err *huffman.FormatError
+	if errors.As(err, &format_err) {
+		return EXIT_FORMAT
+	}
+
+	return EXIT_IO
+}
+
+// Adds or strips the .dense suffix
+func outputName(input_name string, decode bool) (output_name string, err error) {
+	if decode {
+		if !strings.HasSuffix(input_name, SUFFIX) || input_name == SUFFIX {
+			err = fmt.Errorf("%s: unknown suffix, use -o or -c", input_name)
+			return
+		}
+		output_name = strings.TrimSuffix(input_name, SUFFIX)
+		return
+	}
+
+	if strings.HasSuffix(input_name, SUFFIX) {
+		err = fmt.Errorf("%s: already has %s suffix", input_name, SUFFIX)
+		return
+	}
+	output_name = input_name + SUFFIX
+	return
+}
+
+// Parses a number of bytes with an optional k, M or G suffix
+func parseSize(value string) (size int64, err error) {
+
+	multiplier := int64(1)
+	suffixes := map[string]int64{"k": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+
+	for suffix, suffix_multiplier := range suffixes {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSuffix(value, suffix)
+			multiplier = suffix_multiplier
+			break
+		}
+	}
+
+	if size, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return
+	}
+
+	if size <= 0 {
+		err = errors.New("Size must be positive")
+		return
+	}
+
+	if size > math.MaxInt64/multiplier {
+		err = errors.New("Size is too large")
+		return
+	}
+
+	size *= multiplier
+	return
+}
+
+// Whether both paths name the same file, which need not exist
+func sameFile(path, other_path string) bool {
+
+	stat, err := os.Stat(path)
+	other_stat, other_err := os.Stat(other_path)
+	if err == nil && other_err == nil {
+		return os.SameFile(stat, other_stat)
+	}
+
+	abs_path, err := filepath.Abs(path)
+	other_abs_path, other_err := filepath.Abs(other_path)
+	return err == nil && other_err == nil && abs_path == other_abs_path
+}
+
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func displayName(file_name string) string {
+	if file_name == "" {
+		return "<stdin>"
+	}
+	return file_name
+}
+
+// Trains a table on the input and writes it to a table file
+func trainTable(reader io.Reader, table_name string, force bool) int {
+
+	table, err := huffman.TrainTable(reader)
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+
+	return writeFile(table_name, table, force)
+}
+
+// Atomically writes a table or dictionary file
+func writeFile(file_name string, content io.WriterTo, force bool) int {
+
+	if _, err := os.Stat(file_name); !os.IsNotExist(err) && !force {
+		return fail(EXIT_IO, "File '%s' exists already. Use -f to overwrite.", file_name)
+	}
+
+	file, err := createAtomic(file_name)
+	if err != nil {
+		return fail(EXIT_IO, "Could not create file '%s': %s", file_name, err)
+	}
+	defer file.Abort()
+
+	if _, err = content.WriteTo(file); err == nil {
+		err = file.Commit(nil)
+	}
+
+	if err != nil {
+		return fail(EXIT_IO, "Could not write file '%s': %s", file_name, err)
+	}
+	return EXIT_OK
+}
+
+func readTable(table_name string) (table *huffman.Table, err error) {
+
+	table_file, err := os.Open(table_name)
+	if err != nil {
+		return
+	}
+	defer table_file.Close()
+
+	return huffman.ReadTable(table_file)
+}
+
+func readDictionary(dictionary_name string) (dictionary *huffman.Dictionary, err error) {
+
+	dictionary_file, err := os.Open(dictionary_name)
 	if err != nil {
-		fmt.Printf("An error occorred: '%s'\n", err)
+		return
 	}
+	defer dictionary_file.Close()
 
+	return huffman.ReadDictionary(dictionary_file)
+}
+
+// Reads a key file holding 32 raw bytes, or 64 hexadecimal digits
+func readKey(key_name string) (key []byte, err error) {
+
+	content, err := os.ReadFile(key_name)
+	if err != nil {
+		return
+	}
+
+	if len(content) == huffman.KEY_SIZE {
+		return content, nil
+	}
+
+	key, err = hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != huffman.KEY_SIZE {
+		return nil, huffman.ErrInvalidKey
+	}
+	return
+}
+
+// Reads the first line of a passphrase file
+func readPassphrase(passphrase_name string) (passphrase string, err error) {
+
+	content, err := os.ReadFile(passphrase_name)
+	if err != nil {
+		return
+	}
+
+	passphrase, _, _ = strings.Cut(string(content), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+
+	if passphrase == "" {
+		err = errors.New("Passphrase is empty")
+	}
+	return
+}
+
+// Reads the header of a compressed file and seeks back to its start
+func readHeader(file *os.File, options huffman.DecodeOptions) (header *huffman.Header, err error) {
+
+	reader, err := huffman.NewReaderOptions(file, options)
+	if err != nil {
+		return
+	}
+	reader.Close()
+
+	header = &reader.Header
+	_, err = file.Seek(0, io.SeekStart)
+	return
+}
+
+// Returns the header describing a file to compress
+func fileHeader(file *os.File) (header *huffman.Header, err error) {
+
+	stat, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	header = &huffman.Header{
+		Name:    filepath.Base(file.Name()),
+		ModTime: stat.ModTime(),
+		Mode:    stat.Mode().Perm(),
+		HasSize: stat.Mode().IsRegular(),
+		Size:    stat.Size()}
+	return
+}
+
+// File info of a decompressed file, with the permissions and modification time from its header where stored
+type headerInfo struct {
+	os.FileInfo
+	header *huffman.Header
+}
+
+func (info headerInfo) Mode() os.FileMode {
+	if info.header.Mode != 0 {
+		return info.header.Mode
+	}
+	return info.FileInfo.Mode()
+}
+
+func (info headerInfo) ModTime() time.Time {
+	if !info.header.ModTime.IsZero() {
+		return info.header.ModTime
+	}
+	return info.FileInfo.ModTime()
+}
+
+// Prints the blocks and totals of a compressed stream
+func list(reader io.Reader, writer io.Writer, options huffman.DecodeOptions) (err error) {
+
+	info, err := huffman.ListWithOptions(reader, options)
+
+	fmt.Fprintf(writer, "%10s %10s %12s %12s\n", "offset", "block", "compressed", "uncompressed")
+	for _, block := range info.Blocks {
+		fmt.Fprintf(writer, "%10d %10s %12d %12d\n", block.Offset, huffman.BlockName(block.ID),
+			block.Length, block.UncompressedSize)
+	}
+
+	ratio := 0.0
+	if info.UncompressedSize != 0 {
+		ratio = 100.0 * float64(info.CompressedSize) / float64(info.UncompressedSize)
+	}
+
+	fmt.Fprintf(writer, "method:       %s\n", info.Method)
+	fmt.Fprintf(writer, "compressed:   %d\n", info.CompressedSize)
+	fmt.Fprintf(writer, "uncompressed: %d\n", info.UncompressedSize)
+	fmt.Fprintf(writer, "ratio:        %.1f%%\n", ratio)
+
+	if info.HasChecksum {
+		fmt.Fprintf(writer, "checksum:     %s %08x\n", huffman.ChecksumName(info.ChecksumType), info.Checksum)
+	} else {
+		fmt.Fprintf(writer, "checksum:     none\n")
+	}
+
+	if info.HasDictionary {
+		fmt.Fprintf(writer, "dictionary:   %08x\n", info.DictionaryID)
+	}
+
+	if info.Header != nil && info.Header.Name != "" {
+		fmt.Fprintf(writer, "name:         %s\n", info.Header.Name)
+	}
+
+	if info.Encrypted {
+		fmt.Fprintf(writer, "encryption:   aes-256-gcm\n")
+	}
+
+	if info.HasParity {
+		fmt.Fprintf(writer, "corrected:    %d bytes\n", info.Corrected)
+	}
+
+	if info.Members > 1 {
+		fmt.Fprintf(writer, "members:      %d\n", info.Members)
+	}
+	return
 }
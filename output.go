@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Mode of output files when the input does not come from a file
+const DEFAULT_OUTPUT_MODE = 0644
+
+// Output file which is written to a temporary file in the target directory
+// and only renamed to its final name when all data was written successfully.
+type atomicFile struct {
+	*os.File
+	path        string
+	abort_once  sync.Once
+	signal_once sync.Once
+	signals     chan os.Signal
+}
+
+// Creates a temporary file next to path, which is removed on SIGINT and SIGTERM
+func createAtomic(path string) (file *atomicFile, err error) {
+
+	temp_file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+
+	file = &atomicFile{
+		File:    temp_file,
+		path:    path,
+		signals: make(chan os.Signal, 1)}
+
+	signal.Notify(file.signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		if _, ok := <-file.signals; ok {
+			file.Abort()
+			os.Exit(EXIT_INTERRUPTED)
+		}
+	}()
+
+	return
+}
+
+// Syncs the temporary file and renames it to its final name.
+// If source is not nil, its permissions and modification time are copied.
+func (file *atomicFile) Commit(source os.FileInfo) (err error) {
+
+	defer func() {
+		if err != nil {
+			file.Abort()
+		}
+	}()
+
+	mode := os.FileMode(DEFAULT_OUTPUT_MODE)
+	mod_time := time.Time{}
+	if source != nil {
+		mode = source.Mode().Perm()
+		mod_time = source.ModTime()
+	}
+
+	if err = file.Sync(); err != nil {
+		return
+	}
+
+	if err = file.Chmod(mode); err != nil {
+		return
+	}
+
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	if !mod_time.IsZero() {
+		if err = os.Chtimes(file.Name(), mod_time, mod_time); err != nil {
+			return
+		}
+	}
+
+	if err = os.Rename(file.Name(), file.path); err != nil {
+		return
+	}
+
+	file.stopSignals()
+	return
+}
+
+// Closes and removes the temporary file
+func (file *atomicFile) Abort() {
+	file.abort_once.Do(func() {
+		file.Close()
+		os.Remove(file.Name())
+	})
+	file.stopSignals()
+}
+
+func (file *atomicFile) stopSignals() {
+	file.signal_once.Do(func() {
+		signal.Stop(file.signals)
+		close(file.signals)
+	})
+}
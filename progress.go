@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Minimal time between two redraws of the progress bar
+const PROGRESS_REDRAW_INTERVAL = 100 * time.Millisecond
+
+const PROGRESS_BAR_WIDTH = 40
+
+// Progress bar drawn on a single terminal line
+type progressBar struct {
+	writer    io.Writer
+	total     int64
+	last_draw time.Time
+	drawn     bool
+}
+
+// Creates a progress bar, total is the input size or zero if it is unknown
+func newProgressBar(writer io.Writer, total int64) *progressBar {
+	return &progressBar{
+		writer: writer,
+		total:  total}
+}
+
+// Redraws the progress bar, unless it was redrawn very recently
+func (bar *progressBar) update(bytes_in, bytes_out int64) {
+
+	now := time.Now()
+	if now.Sub(bar.last_draw) < PROGRESS_REDRAW_INTERVAL {
+		return
+	}
+	bar.last_draw = now
+	bar.drawn = true
+
+	sizes := fmt.Sprintf("%s -> %s", formatSize(bytes_in), formatSize(bytes_out))
+
+	if bar.total <= 0 {
+		fmt.Fprintf(bar.writer, "\r%-30s", sizes)
+		return
+	}
+
+	fraction := float64(bytes_in) / float64(bar.total)
+	if fraction > 1.0 {
+		fraction = 1.0
+	}
+
+	filled := int(fraction * PROGRESS_BAR_WIDTH)
+	fmt.Fprintf(bar.writer, "\r[%s%s] %3.0f%% %-30s", strings.Repeat("=", filled),
+		strings.Repeat(" ", PROGRESS_BAR_WIDTH-filled), 100.0*fraction, sizes)
+}
+
+// Clears the progress bar line
+func (bar *progressBar) finish() {
+	if bar.drawn {
+		fmt.Fprintf(bar.writer, "\r%s\r", strings.Repeat(" ", PROGRESS_BAR_WIDTH+37))
+	}
+}
+
+// Formats a number of bytes in a human readable way
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
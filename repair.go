@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/lk16/dense/huffman"
+	"os"
+)
+
+// Corrects the damaged bytes of a compressed file with parity, in place unless -o is used
+func runRepair(args []string) int {
+
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	flag_output_file := flags.String("o", "", "Write the repaired file here instead of replacing the input.")
+	flag_force := flags.Bool("f", false, "Overwrite an existing output file.")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fail(EXIT_USAGE, "Expected one input file.")
+	}
+
+	input_name := flags.Arg(0)
+	output_name := *flag_output_file
+	if output_name == "" {
+		output_name = input_name
+	} else if _, err := os.Stat(output_name); !os.IsNotExist(err) && !*flag_force {
+		return fail(EXIT_IO, "File '%s' exists already. Use -f to overwrite.", output_name)
+	}
+
+	input_file, err := os.Open(input_name)
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+	defer input_file.Close()
+
+	input_stat, err := input_file.Stat()
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+
+	output_file, err := createAtomic(output_name)
+	if err != nil {
+		return fail(EXIT_IO, "Could not create file '%s': %s", output_name, err)
+	}
+	defer output_file.Abort()
+
+	corrected, err := huffman.Repair(input_file, output_file)
+	if errors.Is(err, huffman.ErrNoParity) {
+		return fail(EXIT_USAGE, "%s: %s", input_name, err)
+	}
+	if err != nil {
+		return fail(exitCode(err), "%s: %s", input_name, err)
+	}
+
+	if err = output_file.Commit(input_stat); err != nil {
+		return fail(EXIT_IO, "Could not write file '%s': %s", output_name, err)
+	}
+
+	fmt.Printf("%s: corrected %d bytes\n", input_name, corrected)
+	return EXIT_OK
+}
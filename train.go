@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"github.com/lk16/dense/huffman"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Trains a dictionary on sample files, or on stdin if no files are passed.
+// Directories are searched for sample files recursively.
+func runTrain(args []string) int {
+
+	flags := flag.NewFlagSet("train", flag.ExitOnError)
+	flag_output_file := flags.String("o", "", "Dictionary file to write")
+	flag_force := flags.Bool("f", false, "Overwrite an existing dictionary file.")
+	flags.Parse(args)
+
+	if *flag_output_file == "" {
+		return fail(EXIT_USAGE, "Expected a dictionary file name, use -o.")
+	}
+
+	var sample_names []string
+	for _, arg := range flags.Args() {
+		err := filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && entry.Type().IsRegular() {
+				sample_names = append(sample_names, path)
+			}
+			return err
+		})
+
+		if err != nil {
+			return fail(EXIT_IO, "%s", err)
+		}
+	}
+
+	samples := []io.Reader{os.Stdin}
+	if flags.NArg() > 0 {
+		samples = nil
+	}
+
+	// Only one sample file is open at a time, directories may hold more files than can be open
+	for _, sample_name := range sample_names {
+		samples = append(samples, &sampleFile{name: sample_name})
+	}
+
+	dictionary, err := huffman.TrainDictionary(samples...)
+	if err != nil {
+		return fail(EXIT_IO, "%s", err)
+	}
+
+	return writeFile(*flag_output_file, dictionary, *flag_force)
+}
+
+// Sample file which is opened on the first read and closed once it is read completely
+type sampleFile struct {
+	name string
+	file *os.File
+	done bool
+}
+
+func (sample *sampleFile) Read(buff []byte) (n int, err error) {
+	if sample.done {
+		return 0, io.EOF
+	}
+
+	if sample.file == nil {
+		if sample.file, err = os.Open(sample.name); err != nil {
+			return
+		}
+	}
+
+	n, err = sample.file.Read(buff)
+	if err != nil {
+		sample.file.Close()
+		sample.done = true
+	}
+	return
+}